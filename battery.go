@@ -0,0 +1,92 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// MAX17048-style fuel gauge registers.
+const (
+	fuelGaugeDefaultI2CAddress = 0x36
+	fuelGaugeRegVCell          = 0x02
+	fuelGaugeRegSOC            = 0x04
+	fuelGaugeRegCRate          = 0x16
+)
+
+type BatteryStatus struct {
+	BatteryPercent          float64 `json:"batteryPercent"`
+	VoltageVolts            float64 `json:"voltageVolts"`
+	EstimatedRuntimeMinutes float64 `json:"estimatedRuntimeMinutes,omitempty"`
+	ReadAtEpochSeconds      int64   `json:"readAtEpochSeconds"`
+}
+
+func readFuelGauge(dev *i2cDevice) (BatteryStatus, error) {
+	vcell, err := dev.readRegister16(fuelGaugeRegVCell)
+	if err != nil {
+		return BatteryStatus{}, err
+	}
+	soc, err := dev.readRegister16(fuelGaugeRegSOC)
+	if err != nil {
+		return BatteryStatus{}, err
+	}
+	crate, err := dev.readRegister16(fuelGaugeRegCRate)
+	if err != nil {
+		return BatteryStatus{}, err
+	}
+
+	status := BatteryStatus{
+		BatteryPercent:     round(float64(soc)/256.0, 1),
+		VoltageVolts:       round(float64(vcell)*78.125e-6, 3),
+		ReadAtEpochSeconds: time.Now().Unix(),
+	}
+	// CRATE is signed, 0.208 %/hour per LSB; only a discharge predicts runtime
+	percentPerHour := float64(int16(crate)) * 0.208
+	if percentPerHour < 0 {
+		status.EstimatedRuntimeMinutes = round(status.BatteryPercent/-percentPerHour*60.0, 0)
+	}
+	return status, nil
+}
+
+// runBatteryMonitor polls the fuel gauge every BatteryPollSeconds, keeping
+// the latest reading for /api/v1/power. A gauge that cannot be opened or
+// read is retried on the next poll.
+func (app *App) runBatteryMonitor() {
+	var dev *i2cDevice
+	lastErr := ""
+	for {
+		var status BatteryStatus
+		var err error
+		if dev == nil {
+			cfg := app.currentConfig()
+			dev, err = openI2C(cfg.BatteryI2CBus, cfg.BatteryI2CAddress)
+		}
+		if err == nil {
+			status, err = readFuelGauge(dev)
+		}
+		if err != nil {
+			if err.Error() != lastErr {
+				log.Printf("battery: %v", err)
+				lastErr = err.Error()
+			}
+			if dev != nil {
+				_ = dev.Close()
+				dev = nil
+			}
+		} else {
+			lastErr = ""
+			app.lock()
+			app.battery = &status
+			app.unlock()
+		}
+		time.Sleep(seconds(app.currentConfig().BatteryPollSeconds))
+	}
+}
+
+func (app *App) batteryStatus() (BatteryStatus, bool) {
+	app.lock()
+	defer app.unlock()
+	if app.battery == nil {
+		return BatteryStatus{}, false
+	}
+	return *app.battery, true
+}
@@ -0,0 +1,43 @@
+package main
+
+import "time"
+
+// sampleBuffer is an in-memory, time-ordered per-session buffer that can
+// give up its oldest entry when memory is tight.
+type sampleBuffer interface {
+	len() int
+	oldest() (time.Time, bool)
+	dropOldest()
+}
+
+func (app *App) sampleBuffers() []sampleBuffer {
+	return []sampleBuffer{&app.Session.History, &app.Session.Track}
+}
+
+// enforceSampleBudget caps the combined size of the session buffers at
+// MaxInMemorySamples by evicting the oldest entry across all of them.
+// Caller must hold the lock.
+func (app *App) enforceSampleBudget() {
+	limit := app.Config.MaxInMemorySamples
+	if limit <= 0 {
+		return
+	}
+	buffers := app.sampleBuffers()
+	total := 0
+	for _, b := range buffers {
+		total += b.len()
+	}
+	for ; total > limit; total-- {
+		var victim sampleBuffer
+		var victimTime time.Time
+		for _, b := range buffers {
+			if t, ok := b.oldest(); ok && (victim == nil || t.Before(victimTime)) {
+				victim, victimTime = b, t
+			}
+		}
+		if victim == nil {
+			return
+		}
+		victim.dropOldest()
+	}
+}
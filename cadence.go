@@ -0,0 +1,60 @@
+package main
+
+import (
+	"time"
+
+	"github.com/warthog618/go-gpiocdev"
+)
+
+// openCadence requests the crank sensor line alongside the wheel line.
+func (a *App) openCadence() error {
+	cfg := a.currentConfig()
+	line, err := gpiocdev.RequestLine(cfg.chipFor(cfg.CadenceChipName), cfg.CadenceLineOffset,
+		gpiocdev.AsInput,
+		gpiocdev.WithPullUp,
+		gpiocdev.WithFallingEdge,
+		gpiocdev.WithEventHandler(a.onCadenceEdge),
+		gpiocdev.WithMonotonicEventClock,
+	)
+	if err != nil {
+		return err
+	}
+	a.lock()
+	a.CadenceLine = line
+	a.unlock()
+	return nil
+}
+
+// onCadenceEdge times one crank revolution per falling edge.
+func (app *App) onCadenceEdge(event gpiocdev.LineEvent) {
+	if event.Type != gpiocdev.LineEventFallingEdge {
+		return
+	}
+	previous := time.Duration(app.cadenceLastEdge.Swap(int64(event.Timestamp)))
+	if previous == 0 || event.Timestamp-previous <= debounceInterval {
+		return
+	}
+
+	app.lock()
+	defer app.unlock()
+	dt := event.Timestamp - previous
+	if dt.Seconds() < app.Config.IdleTimeoutSeconds {
+		app.Session.CadenceInterval = dt
+	} else {
+		// first stroke after a rest says nothing about cadence
+		app.Session.CadenceInterval = 0
+	}
+	app.Session.LastCadenceWall = time.Now()
+	app.streams.notify()
+}
+
+// cadenceRpm is the crank rate from the latest revolution, or zero once
+// the cranks have been still for the idle timeout. Caller must hold the
+// lock.
+func (app *App) cadenceRpm(now time.Time) float64 {
+	s := &app.Session
+	if s.CadenceInterval <= 0 || now.Sub(s.LastCadenceWall).Seconds() >= app.Config.IdleTimeoutSeconds {
+		return 0
+	}
+	return 60e9 / float64(s.CadenceInterval.Nanoseconds())
+}
@@ -0,0 +1,66 @@
+package main
+
+import "math"
+
+type CaloriePoint struct {
+	SpeedKilometresPerHour float64 `json:"speedKilometresPerHour"`
+	Met                    float64 `json:"met,omitempty"`
+	Watts                  float64 `json:"watts,omitempty"`
+	KiloCaloriesPerHour    float64 `json:"kiloCaloriesPerHour"`
+}
+
+type CalorieCurve struct {
+	Model                   string         `json:"model"`
+	BodyWeightKilograms     float64        `json:"bodyWeightKilograms"`
+	CalorieCorrectionFactor float64        `json:"calorieCorrectionFactor"`
+	Points                  []CaloriePoint `json:"points"`
+}
+
+// kcalPerMinute is the MET-based burn rate at a steady speed, including
+// the configured correction factor.
+func (c Config) kcalPerMinute(speedKmh float64) float64 {
+	return c.kcalPerMinuteAtMet(metFromSpeed(speedKmh))
+}
+
+func (c Config) kcalPerMinuteAtMet(met float64) float64 {
+	return (met * 3.5 * c.BodyWeightKilograms) / 200.0 * c.CalorieCorrectionFactor
+}
+
+// restingMet is the metabolic rate the warm-up ramp starts from.
+const restingMet = 1.0
+
+// rampedMet eases met up from rest over KcalRampSeconds of continuous
+// movement.
+func (c Config) rampedMet(met, movingForSeconds float64) float64 {
+	if c.KcalRampSeconds <= 0 || met <= restingMet {
+		return met
+	}
+	progress := math.Min(1, movingForSeconds/c.KcalRampSeconds)
+	return restingMet + (met-restingMet)*progress
+}
+
+// calorieCurve tabulates kcal/hour from 0 to 40 km/h in 2 km/h steps for
+// the model KiloCalories follows.
+func (c Config) calorieCurve() CalorieCurve {
+	curve := CalorieCurve{
+		Model:                   KcalModelMet,
+		BodyWeightKilograms:     c.BodyWeightKilograms,
+		CalorieCorrectionFactor: c.CalorieCorrectionFactor,
+	}
+	if c.KcalModel == KcalModelPower {
+		curve.Model = KcalModelPower
+	}
+	for speed := 0.0; speed <= 40; speed += 2 {
+		point := CaloriePoint{SpeedKilometresPerHour: speed}
+		if curve.Model == KcalModelPower {
+			watts := c.estimateWatts(speed)
+			point.Watts = round(watts, 0)
+			point.KiloCaloriesPerHour = round(c.kcalPerMinuteAtWatts(watts)*60.0, 1)
+		} else {
+			point.Met = metFromSpeed(speed)
+			point.KiloCaloriesPerHour = round(c.kcalPerMinute(speed)*60.0, 1)
+		}
+		curve.Points = append(curve.Points, point)
+	}
+	return curve
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultConfig is the configuration used when no file or environment
+// variable says otherwise.
+func defaultConfig() Config {
+	return Config{
+		ChipName:              "gpiochip0",
+		LineOffset:            17,
+		LineChipName:          "",
+		CircumferenceInMetres: 1.41,
+		HttpPort:              "80",
+		BodyWeightKilograms:   85,
+		IdleTimeoutSeconds:    2.0,
+		PulseMode:             PulseModeWheel,
+		SplitDistanceMetres:   1000,
+		UploadEndpoint:        "",
+		UploadRetrySeconds:    30,
+		UploadSpoolDir:        "",
+		UploadSpoolMax:        100,
+		Timezone:              "",
+		DailyResetTime:        "",
+		DailySummaryPath:      "",
+		JoulesPerMetre:        0,
+
+		HistorySize:             3600,
+		HistoryIntervalSeconds:  1,
+		HistoryToleranceSeconds: 5,
+		MaxInMemorySamples:      0,
+		Debug:                   false,
+
+		HttpConcurrency:        0,
+		HttpDisableKeepalive:   false,
+		HttpIdleTimeoutSeconds: 0,
+		HttpReadTimeoutSeconds: 0,
+
+		NfcI2CBus:      "",
+		NfcI2CAddress:  pn532DefaultI2CAddress,
+		NfcPollSeconds: 1,
+
+		StatsTcpPort: "",
+
+		CoastingModel:                           false,
+		CoastRollingDecelMetresPerSecondSquared: 0.05,
+		CoastDragPerMetre:                       0.003,
+
+		BatteryI2CBus:      "",
+		BatteryI2CAddress:  fuelGaugeDefaultI2CAddress,
+		BatteryPollSeconds: 60,
+
+		CalorieCorrectionFactor: 1.0,
+
+		StatsRateLimitRequests:      0,
+		StatsRateLimitWindowSeconds: 1,
+
+		IdleByIntervalGrowth:     false,
+		IdleIntervalGrowthFactor: 2.0,
+
+		ResponseHeaders: map[string]string{},
+
+		SprintEntryKmh:   35,
+		SprintExitKmh:    30,
+		SprintMinSeconds: 3,
+
+		SyslogAddress: "",
+
+		MaxSnapshotGapSeconds: 5,
+
+		SnapshotTickSeconds: 1,
+
+		KcalRampSeconds: 0,
+
+		ProfilesDir: "",
+
+		SnapshotTimeoutMillis: 0,
+
+		GoogleFitClientID:     "",
+		GoogleFitClientSecret: "",
+		GoogleFitRefreshToken: "",
+
+		ClassifyRides:             false,
+		RideTypeIntervalSprints:   4,
+		RideTypeCommuteStopsPerKm: 0.5,
+		RideTypeRecoveryKmh:       18,
+
+		DiagSampleSeconds: 1,
+
+		SkipFirstPulseDistance: false,
+
+		SpeedWindowSize: 5,
+
+		HealthCheckSensor:         false,
+		HealthSensorWindowSeconds: 60,
+
+		TrackpointIntervalSeconds: 5,
+
+		StaleAfterSeconds: 10,
+
+		ComputeAllCalorieModels: false,
+
+		StdoutStatsNdjson:          false,
+		StdoutStatsIntervalSeconds: 1,
+
+		RestoreAlways: false,
+
+		MinMovingSecondsForAverages: 0,
+
+		GpsCalibrationSamples:     10,
+		GpsAutoApplyCircumference: false,
+
+		SessionsDir:         "",
+		CheckpointSeconds:   30,
+		ResumeWithinSeconds: 900,
+
+		StreamIntervalSeconds: 1,
+
+		CadenceChipName:   "",
+		CadenceLineOffset: 0,
+
+		EstimatePower:          false,
+		RollingResistanceCoeff: 0.005,
+		DragAreaSquareMetres:   0.32,
+		BikeWeightKilograms:    10,
+		KcalModel:              KcalModelMet,
+
+		StravaClientID:     "",
+		StravaClientSecret: "",
+		StravaRefreshToken: "",
+	}
+}
+
+// loadConfig starts from the defaults, overlays the config file at path if
+// given (JSON, or YAML for .yaml/.yml), then any VITAL_* environment
+// variables. Keys match Config field names, case-insensitively; unknown
+// keys are an error so typos don't go unnoticed.
+func loadConfig(path string) (Config, error) {
+	cfg := defaultConfig()
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return Config{}, err
+		}
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".yaml", ".yml":
+			// go through JSON so both formats share the same key rules
+			var doc map[string]any
+			if err := yaml.Unmarshal(data, &doc); err != nil {
+				return Config{}, fmt.Errorf("%s: %w", path, err)
+			}
+			if data, err = json.Marshal(doc); err != nil {
+				return Config{}, fmt.Errorf("%s: %w", path, err)
+			}
+		}
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&cfg); err != nil {
+			return Config{}, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if err := applyEnv(&cfg); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// applyEnv sets each Config field from VITAL_<FIELD_NAME> when present,
+// e.g. VITAL_HTTP_PORT or VITAL_CIRCUMFERENCE_IN_METRES. Strings are taken
+// as is; other types are parsed as JSON values.
+func applyEnv(cfg *Config) error {
+	value := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		name := "VITAL_" + screamingSnake(field.Name)
+		raw, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		target := value.Field(i)
+		if target.Kind() == reflect.String {
+			target.SetString(raw)
+			continue
+		}
+		if err := json.Unmarshal([]byte(raw), target.Addr().Interface()); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// screamingSnake turns HttpPort into HTTP_PORT and NfcI2CBus into
+// NFC_I2C_BUS.
+func screamingSnake(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || (unicode.IsUpper(prev) && nextLower) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// DailyTotals accumulates finished sessions between daily resets.
+type DailyTotals struct {
+	Since          time.Time
+	Sessions       int
+	DistanceMetres float64
+	MovingSeconds  float64
+	KiloCalories   float64
+}
+
+type DailySummary struct {
+	From               string  `json:"from"`
+	To                 string  `json:"to"`
+	Sessions           int     `json:"sessions"`
+	DistanceKilometres float64 `json:"distanceKilometres"`
+	MovingMinutes      float64 `json:"movingMinutes"`
+	KiloCalories       float64 `json:"kiloCalories"`
+}
+
+// addSessionToDaily folds the current session, which counted pulses, into
+// the daily totals. Caller must hold the lock.
+func (app *App) addSessionToDaily(pulses uint64) {
+	if pulses == 0 {
+		return
+	}
+	app.Daily.Sessions++
+	app.Daily.DistanceMetres += app.distanceMetres(pulses)
+	app.Daily.MovingSeconds += app.Session.MovingSeconds
+	app.Daily.KiloCalories += app.Session.KiloCalories
+}
+
+// rollDaily returns the summary of the day that just ended and starts a new
+// one.
+func (app *App) rollDaily(now time.Time) DailySummary {
+	app.lock()
+	defer app.unlock()
+	summary := DailySummary{
+		From:               app.Daily.Since.In(app.location).Format(time.RFC3339),
+		To:                 now.In(app.location).Format(time.RFC3339),
+		Sessions:           app.Daily.Sessions,
+		DistanceKilometres: round(app.Daily.DistanceMetres/1000.0, 3),
+		MovingMinutes:      round(app.Daily.MovingSeconds/60.0, 2),
+		KiloCalories:       round(app.Daily.KiloCalories, 1),
+	}
+	app.Daily = DailyTotals{Since: now}
+	return summary
+}
+
+// appendDailySummary writes the summary as one JSON line to path.
+func appendDailySummary(path string, summary DailySummary) error {
+	line, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"time"
+
+	"github.com/warthog618/go-gpiocdev"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	maxSimulateSeconds = 600
+	// maxSimulateRpm keeps synthetic pulses 20 ms apart, well clear of the
+	// debounce window, so none are dropped as bounce
+	maxSimulateRpm = 3000
+)
+
+// simulateInterval is the time between pulses at rpm, which must be in
+// (0, maxSimulateRpm].
+func simulateInterval(rpm float64) time.Duration {
+	return time.Duration(60.0 / rpm * float64(time.Second))
+}
+
+// monotonicNow reads CLOCK_MONOTONIC, the clock gpiocdev stamps line events
+// with, so synthetic pulses line up with real ones.
+func monotonicNow() time.Duration {
+	var ts unix.Timespec
+	_ = unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts)
+	return time.Duration(ts.Nano())
+}
+
+// simulate feeds synthetic falling edges through onEdge at rpm for the given
+// duration and returns the resulting stats. The snapshot ticker accrues
+// moving time and kcal meanwhile, as for real pulses.
+func (app *App) simulate(rpm, seconds float64) Stats {
+	interval := simulateInterval(rpm)
+	deadline := time.Now().Add(time.Duration(seconds * float64(time.Second)))
+
+	pulses := time.NewTicker(interval)
+	defer pulses.Stop()
+	for now := range pulses.C {
+		if !now.Before(deadline) {
+			break
+		}
+		app.onEdge(gpiocdev.LineEvent{Type: gpiocdev.LineEventFallingEdge, Timestamp: monotonicNow()})
+	}
+	return app.snapshot()
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// EdgeRate is the raw GPIO event rate over the last sample period, before
+// debouncing, for checking a sensor while spinning the wheel by hand.
+type EdgeRate struct {
+	RisingPerSecond  float64 `json:"risingPerSecond"`
+	FallingPerSecond float64 `json:"fallingPerSecond"`
+	SampledAtEpochMs int64   `json:"sampledAtEpochMs"`
+}
+
+// edgeCounters counts every edge the kernel delivers. onEdge bumps them
+// without the lock; runEdgeRateSampler drains them on a timer.
+type edgeCounters struct {
+	rising  atomic.Uint64
+	falling atomic.Uint64
+}
+
+func (e *edgeCounters) clear() {
+	e.rising.Store(0)
+	e.falling.Store(0)
+}
+
+// runEdgeRateSampler turns the edge counters into a per-second rate every
+// DiagSampleSeconds.
+func (app *App) runEdgeRateSampler() {
+	every := seconds(app.currentConfig().DiagSampleSeconds)
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	last := time.Now()
+	for now := range ticker.C {
+		elapsed := now.Sub(last).Seconds()
+		last = now
+		rising := app.edges.rising.Swap(0)
+		falling := app.edges.falling.Swap(0)
+		if elapsed <= 0 {
+			continue
+		}
+		app.lock()
+		app.edgeRate = EdgeRate{
+			RisingPerSecond:  round(float64(rising)/elapsed, 2),
+			FallingPerSecond: round(float64(falling)/elapsed, 2),
+			SampledAtEpochMs: now.UnixMilli(),
+		}
+		app.unlock()
+	}
+}
+
+// Diag is the live edge rate plus the pulse handling settings in effect,
+// for checking that config resolved as intended.
+type Diag struct {
+	EdgeRate
+	DebounceMillis  float64 `json:"debounceMillis"`
+	SpeedFilter     string  `json:"speedFilter"`
+	SpeedWindowSize int     `json:"speedWindowSize,omitempty"`
+	PulseMode       string  `json:"pulseMode"`
+	MetresPerPulse  float64 `json:"metresPerPulse"`
+	// magnets read per revolution; a quadrature encoder has none
+	MagnetCount int `json:"magnetCount"`
+	// quadrature only: signed position in full cycles, and "forward" or
+	// "backward" for the latest step
+	EncoderPosition  *int64 `json:"encoderPosition,omitempty"`
+	EncoderDirection string `json:"encoderDirection,omitempty"`
+}
+
+func (app *App) diag() Diag {
+	app.lock()
+	defer app.unlock()
+	d := Diag{
+		EdgeRate:       app.edgeRate,
+		DebounceMillis: float64(debounceInterval) / float64(time.Millisecond),
+		SpeedFilter:    "last",
+		PulseMode:      app.Config.PulseMode,
+		MetresPerPulse: round(app.metresPerPulse(), 4),
+		// one magnet passing the reed switch is one pulse
+		MagnetCount: 1,
+	}
+	if app.Config.quadrature() {
+		// the decoder rejects bounce itself, so pulses skip the debounce
+		d.DebounceMillis, d.MagnetCount = 0, 0
+		if q := app.quadrature.Load(); q != nil {
+			position, direction := q.reading()
+			d.EncoderPosition = &position
+			switch direction {
+			case 1:
+				d.EncoderDirection = "forward"
+			case -1:
+				d.EncoderDirection = "backward"
+			}
+		}
+	}
+	if d.PulseMode == "" {
+		d.PulseMode = PulseModeWheel
+	}
+	if n := app.Config.SpeedWindowSize; n > 0 {
+		d.SpeedFilter, d.SpeedWindowSize = "mean", n
+	}
+	return d
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+// FIT base types used below
+const (
+	fitEnum    = 0x00
+	fitUint8   = 0x02
+	fitUint16  = 0x84
+	fitUint32  = 0x86
+	fitUint32z = 0x8c
+)
+
+// FIT global message numbers
+const (
+	fitFileID   = 0
+	fitSession  = 18
+	fitLap      = 19
+	fitRecord   = 20
+	fitActivity = 34
+)
+
+// FIT timestamps count seconds from 1989-12-31T00:00:00Z.
+const fitEpoch = 631065600
+
+const (
+	fitSportCycling       = 2
+	fitSubSportIndoor     = 6
+	fitEventSession       = 8
+	fitEventLap           = 9
+	fitEventActivity      = 26
+	fitEventTypeStop      = 1
+	fitManufacturerDevel  = 255
+	fitFileTypeActivity   = 4
+	fitProfileVersion     = 2132
+	fitProtocolVersion    = 0x20
+	fitInvalidUint8       = 0xff
+	fitInvalidUint16      = 0xffff
+	fitActivityTypeManual = 0
+)
+
+type fitField struct {
+	num, size, baseType byte
+}
+
+type fitWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *fitWriter) define(local byte, global uint16, fields ...fitField) {
+	w.buf.WriteByte(0x40 | local)
+	w.buf.WriteByte(0) // reserved
+	w.buf.WriteByte(0) // little endian
+	_ = binary.Write(&w.buf, binary.LittleEndian, global)
+	w.buf.WriteByte(byte(len(fields)))
+	for _, f := range fields {
+		w.buf.Write([]byte{f.num, f.size, f.baseType})
+	}
+}
+
+// data writes one message; values must match the definition's sizes.
+func (w *fitWriter) data(local byte, values ...any) {
+	w.buf.WriteByte(local)
+	for _, v := range values {
+		_ = binary.Write(&w.buf, binary.LittleEndian, v)
+	}
+}
+
+var fitCRCTable = [16]uint16{
+	0x0000, 0xcc01, 0xd801, 0x1400, 0xf001, 0x3c00, 0x2800, 0xe401,
+	0xa001, 0x6c00, 0x7800, 0xb401, 0x5000, 0x9c01, 0x8801, 0x4400,
+}
+
+func fitCRC(crc uint16, data []byte) uint16 {
+	for _, b := range data {
+		tmp := fitCRCTable[crc&0xf]
+		crc = (crc >> 4) & 0x0fff
+		crc = crc ^ tmp ^ fitCRCTable[b&0xf]
+		tmp = fitCRCTable[crc&0xf]
+		crc = (crc >> 4) & 0x0fff
+		crc = crc ^ tmp ^ fitCRCTable[(b>>4)&0xf]
+	}
+	return crc
+}
+
+func fitTime(t time.Time) uint32 { return uint32(t.Unix() - fitEpoch) }
+
+// fitScaled stores v*scale, as FIT keeps fractional values in integers.
+func fitScaled(v, scale float64) uint32 { return uint32(math.Round(v * scale)) }
+
+// fit renders the ride as a FIT activity file: file id, one record per
+// track sample, then a single lap, session and activity.
+func (e rideExport) fit() []byte {
+	var w fitWriter
+	start, end := fitTime(e.Start), fitTime(e.End)
+	if end < start {
+		end = start
+	}
+	elapsed := fitScaled(e.End.Sub(e.Start).Seconds(), 1000)
+	if e.End.Before(e.Start) {
+		elapsed = 0
+	}
+	timer := fitScaled(e.MovingSeconds, 1000)
+	distance := fitScaled(e.DistanceMetres, 100)
+	calories := uint16(math.Round(e.KiloCalories))
+
+	w.define(0, fitFileID,
+		fitField{0, 1, fitEnum},
+		fitField{1, 2, fitUint16},
+		fitField{2, 2, fitUint16},
+		fitField{3, 4, fitUint32z},
+		fitField{4, 4, fitUint32},
+	)
+	w.data(0, uint8(fitFileTypeActivity), uint16(fitManufacturerDevel), uint16(1), uint32(e.Start.Unix()), start)
+
+	w.define(1, fitRecord,
+		fitField{253, 4, fitUint32},
+		fitField{5, 4, fitUint32},
+		fitField{6, 2, fitUint16},
+		fitField{4, 1, fitUint8},
+		fitField{7, 2, fitUint16},
+	)
+	for _, p := range e.Points {
+		cadence := uint8(fitInvalidUint8)
+		if p.CadenceRpm > 0 {
+			cadence = uint8(math.Min(254, math.Round(p.CadenceRpm)))
+		}
+		power := uint16(fitInvalidUint16)
+		if p.Watts > 0 {
+			power = uint16(math.Min(65534, math.Round(p.Watts)))
+		}
+		speed := uint16(math.Min(65534, math.Round(p.SpeedKmh/3.6*1000)))
+		w.data(1, fitTime(p.Time), fitScaled(p.DistanceMetres, 100), speed, cadence, power)
+	}
+
+	w.define(2, fitLap,
+		fitField{253, 4, fitUint32},
+		fitField{2, 4, fitUint32},
+		fitField{7, 4, fitUint32},
+		fitField{8, 4, fitUint32},
+		fitField{9, 4, fitUint32},
+		fitField{11, 2, fitUint16},
+		fitField{0, 1, fitEnum},
+		fitField{1, 1, fitEnum},
+		fitField{25, 1, fitEnum},
+	)
+	w.data(2, end, start, elapsed, timer, distance, calories,
+		uint8(fitEventLap), uint8(fitEventTypeStop), uint8(fitSportCycling))
+
+	w.define(3, fitSession,
+		fitField{253, 4, fitUint32},
+		fitField{2, 4, fitUint32},
+		fitField{7, 4, fitUint32},
+		fitField{8, 4, fitUint32},
+		fitField{9, 4, fitUint32},
+		fitField{11, 2, fitUint16},
+		fitField{25, 2, fitUint16},
+		fitField{26, 2, fitUint16},
+		fitField{0, 1, fitEnum},
+		fitField{1, 1, fitEnum},
+		fitField{5, 1, fitEnum},
+		fitField{6, 1, fitEnum},
+	)
+	w.data(3, end, start, elapsed, timer, distance, calories, uint16(0), uint16(1),
+		uint8(fitEventSession), uint8(fitEventTypeStop), uint8(fitSportCycling), uint8(fitSubSportIndoor))
+
+	w.define(4, fitActivity,
+		fitField{253, 4, fitUint32},
+		fitField{0, 4, fitUint32},
+		fitField{1, 2, fitUint16},
+		fitField{2, 1, fitEnum},
+		fitField{3, 1, fitEnum},
+		fitField{4, 1, fitEnum},
+	)
+	w.data(4, end, timer, uint16(1), uint8(fitActivityTypeManual), uint8(fitEventActivity), uint8(fitEventTypeStop))
+
+	records := w.buf.Bytes()
+	header := make([]byte, 12, 14)
+	header[0] = 14
+	header[1] = fitProtocolVersion
+	binary.LittleEndian.PutUint16(header[2:], fitProfileVersion)
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(records)))
+	copy(header[8:], ".FIT")
+	header = binary.LittleEndian.AppendUint16(header, fitCRC(0, header))
+
+	file := append(header, records...)
+	return binary.LittleEndian.AppendUint16(file, fitCRC(0, file))
+}
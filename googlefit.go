@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	googleTokenURL     = "https://oauth2.googleapis.com/token"
+	googleFitURL       = "https://www.googleapis.com/fitness/v1/users/me"
+	googleFitBiking    = 1
+	googleFitAppName   = "vital"
+	googleFitTokenSkew = time.Minute
+)
+
+// GoogleFit writes finished sessions to the Google Fit REST API using an
+// OAuth refresh token, refreshing the access token as it expires.
+type GoogleFit struct {
+	ClientID     string
+	ClientSecret string
+	RefreshToken string
+
+	client      *http.Client
+	accessToken string
+	expiry      time.Time
+	guard       chan struct{}
+}
+
+func NewGoogleFit(clientID, clientSecret, refreshToken string) *GoogleFit {
+	return &GoogleFit{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RefreshToken: refreshToken,
+		client:       &http.Client{Timeout: 15 * time.Second},
+		guard:        make(chan struct{}, 1),
+	}
+}
+
+type googleFitDataSource struct {
+	DataStreamID   string `json:"dataStreamId,omitempty"`
+	DataStreamName string `json:"dataStreamName"`
+	Type           string `json:"type"`
+	Application    struct {
+		Name string `json:"name"`
+	} `json:"application"`
+	DataType struct {
+		Name  string `json:"name"`
+		Field []struct {
+			Name   string `json:"name"`
+			Format string `json:"format"`
+		} `json:"field"`
+	} `json:"dataType"`
+}
+
+// token returns a valid access token, refreshing it when close to expiry.
+func (g *GoogleFit) token() (string, error) {
+	g.guard <- struct{}{}
+	defer func() { <-g.guard }()
+
+	if g.accessToken != "" && time.Now().Before(g.expiry.Add(-googleFitTokenSkew)) {
+		return g.accessToken, nil
+	}
+	form := url.Values{
+		"client_id":     {g.ClientID},
+		"client_secret": {g.ClientSecret},
+		"refresh_token": {g.RefreshToken},
+		"grant_type":    {"refresh_token"},
+	}
+	res, err := g.client.PostForm(googleTokenURL, form)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token refresh: %s", res.Status)
+	}
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("token refresh: %w", err)
+	}
+	g.accessToken = body.AccessToken
+	g.expiry = time.Now().Add(time.Duration(body.ExpiresIn) * time.Second)
+	return g.accessToken, nil
+}
+
+func (g *GoogleFit) call(method, endpoint string, in, out any) error {
+	token, err := g.token()
+	if err != nil {
+		return err
+	}
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, googleFitURL+endpoint, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	res, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("%s %s: %s %s", method, endpoint, res.Status, strings.TrimSpace(string(detail)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+// dataSource finds vital's data source for dataType, creating it on first
+// use.
+func (g *GoogleFit) dataSource(dataType, field string) (string, error) {
+	streamName := "vital-" + strings.TrimPrefix(dataType, "com.google.")
+
+	var list struct {
+		DataSource []googleFitDataSource `json:"dataSource"`
+	}
+	if err := g.call(http.MethodGet, "/dataSources?dataTypeName="+url.QueryEscape(dataType), nil, &list); err != nil {
+		return "", err
+	}
+	for _, source := range list.DataSource {
+		if source.DataStreamName == streamName {
+			return source.DataStreamID, nil
+		}
+	}
+
+	source := googleFitDataSource{DataStreamName: streamName, Type: "raw"}
+	source.Application.Name = googleFitAppName
+	source.DataType.Name = dataType
+	source.DataType.Field = append(source.DataType.Field, struct {
+		Name   string `json:"name"`
+		Format string `json:"format"`
+	}{Name: field, Format: "floatPoint"})
+	var created googleFitDataSource
+	if err := g.call(http.MethodPost, "/dataSources", source, &created); err != nil {
+		return "", err
+	}
+	return created.DataStreamID, nil
+}
+
+// writeTotal records value as a single point spanning the session.
+func (g *GoogleFit) writeTotal(dataType, field string, start, end time.Time, value float64) error {
+	sourceID, err := g.dataSource(dataType, field)
+	if err != nil {
+		return err
+	}
+	datasetID := fmt.Sprintf("%d-%d", start.UnixNano(), end.UnixNano())
+	dataset := map[string]any{
+		"dataSourceId":   sourceID,
+		"minStartTimeNs": start.UnixNano(),
+		"maxEndTimeNs":   end.UnixNano(),
+		"point": []map[string]any{{
+			"dataTypeName":   dataType,
+			"startTimeNanos": start.UnixNano(),
+			"endTimeNanos":   end.UnixNano(),
+			"value":          []map[string]any{{"fpVal": value}},
+		}},
+	}
+	return g.call(http.MethodPatch, "/dataSources/"+url.PathEscape(sourceID)+"/datasets/"+datasetID, dataset, nil)
+}
+
+// upload writes the session's distance and calories, then the cycling
+// session that groups them, and returns the session ID.
+func (g *GoogleFit) upload(record SessionRecord) (string, error) {
+	start := time.Unix(record.StartTimeEpochSeconds, 0)
+	end := time.Unix(record.EndTimeEpochSeconds, 0)
+	if !end.After(start) {
+		end = start.Add(time.Second)
+	}
+
+	if err := g.writeTotal("com.google.distance.delta", "distance", start, end, record.DistanceKilometres*1000.0); err != nil {
+		return "", err
+	}
+	if err := g.writeTotal("com.google.calories.expended", "calories", start, end, record.KiloCalories); err != nil {
+		return "", err
+	}
+
+	id := fmt.Sprintf("vital-%d", record.ID)
+	session := map[string]any{
+		"id":               id,
+		"name":             "vital ride",
+		"startTimeMillis":  start.UnixMilli(),
+		"endTimeMillis":    end.UnixMilli(),
+		"activeTimeMillis": int64(record.MovingMinutes * 60_000),
+		"activityType":     googleFitBiking,
+		"application":      map[string]string{"name": googleFitAppName},
+	}
+	if err := g.call(http.MethodPut, "/sessions/"+id, session, nil); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+func (g *GoogleFit) uploadAndLog(record SessionRecord) {
+	id, err := g.upload(record)
+	if err != nil {
+		log.Printf("google fit: %v", err)
+		return
+	}
+	log.Printf("google fit: created session %s", id)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+// minCalibrationKmh ignores readings too slow for either speed to be
+// trustworthy.
+const minCalibrationKmh = 5.0
+
+var errCalibrationStroke = errors.New("GPS calibration only applies to wheel mode")
+
+type speedPair struct {
+	GpsKmh   float64
+	WheelKmh float64
+}
+
+// gpsCalibration keeps the most recent GPS/wheel speed pairs. It lives on
+// the app rather than the session since it describes the bike.
+type gpsCalibration struct {
+	pairs []speedPair
+}
+
+// addGpsSpeed pairs a GPS reading with the current wheel speed and
+// returns the suggested circumference once GpsCalibrationSamples pairs are
+// in, or zero before that. With GpsAutoApplyCircumference the suggestion is
+// applied to the session and collection starts over.
+func (app *App) addGpsSpeed(gpsKmh float64) (float64, error) {
+	app.lock()
+	defer app.unlock()
+
+	if app.Config.PulseMode == PulseModeStroke {
+		return 0, errCalibrationStroke
+	}
+	wheelKmh := app.statsLocked(time.Now()).SpeedKilometresPerHour
+	if gpsKmh >= minCalibrationKmh && wheelKmh >= minCalibrationKmh {
+		cal := &app.gpsCal
+		cal.pairs = append(cal.pairs, speedPair{GpsKmh: gpsKmh, WheelKmh: wheelKmh})
+		if n := app.Config.GpsCalibrationSamples; len(cal.pairs) > n {
+			cal.pairs = cal.pairs[len(cal.pairs)-n:]
+		}
+	}
+
+	suggested := app.suggestedCircumference()
+	if suggested > 0 && app.Config.GpsAutoApplyCircumference {
+		log.Printf("gps: circumference %.4f m -> %.4f m", app.metresPerPulse(), suggested)
+		app.bankDistance()
+		app.Session.CircumferenceInMetres = suggested
+		app.gpsCal.pairs = nil
+	}
+	return suggested, nil
+}
+
+// suggestedCircumference scales the current circumference by how far GPS
+// speed ran ahead of or behind wheel speed. Caller must hold the lock.
+func (app *App) suggestedCircumference() float64 {
+	pairs := app.gpsCal.pairs
+	if app.Config.GpsCalibrationSamples <= 0 || len(pairs) < app.Config.GpsCalibrationSamples {
+		return 0
+	}
+	var gps, wheel float64
+	for _, p := range pairs {
+		gps += p.GpsKmh
+		wheel += p.WheelKmh
+	}
+	return round(app.metresPerPulse()*gps/wheel, 4)
+}
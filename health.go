@@ -0,0 +1,36 @@
+package main
+
+import "time"
+
+type Health struct {
+	LineOpen bool `json:"lineOpen"`
+	// only reported when HealthCheckSensor is set
+	SensorActive        *bool   `json:"sensorActive,omitempty"`
+	LastPulseAgeSeconds float64 `json:"lastPulseAgeSeconds,omitempty"`
+}
+
+func (h Health) ok() bool {
+	return h.LineOpen && (h.SensorActive == nil || *h.SensorActive)
+}
+
+// health reports whether the sensor line is open and, with
+// HealthCheckSensor, whether pulses are still arriving. A session that has
+// counted pulses is taken as a ride in progress, so going quiet for longer
+// than HealthSensorWindowSeconds before a reset marks the sensor as dead.
+func (app *App) health() Health {
+	app.lock()
+	defer app.unlock()
+
+	h := Health{LineOpen: app.Line != nil || app.Lines != nil || app.simulated}
+	if !app.Config.HealthCheckSensor {
+		return h
+	}
+	active := true
+	if last := app.Session.LastPulseWall; !last.IsZero() && app.pulses.Load() > 0 {
+		age := time.Since(last)
+		h.LastPulseAgeSeconds = round(age.Seconds(), 1)
+		active = age <= seconds(app.Config.HealthSensorWindowSeconds)
+	}
+	h.SensorActive = &active
+	return h
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+type HistoryEntry struct {
+	Time  time.Time
+	Stats Stats
+}
+
+// History holds stats snapshots oldest first, bounded to a capacity.
+type History struct {
+	entries []HistoryEntry
+}
+
+func (h *History) add(entry HistoryEntry, capacity int) {
+	if capacity <= 0 {
+		return
+	}
+	h.entries = append(h.entries, entry)
+	if len(h.entries) > capacity {
+		h.entries = h.entries[len(h.entries)-capacity:]
+	}
+}
+
+func (h *History) len() int { return len(h.entries) }
+
+func (h *History) last() (HistoryEntry, bool) {
+	if len(h.entries) == 0 {
+		return HistoryEntry{}, false
+	}
+	return h.entries[len(h.entries)-1], true
+}
+
+func (h *History) oldest() (time.Time, bool) {
+	if len(h.entries) == 0 {
+		return time.Time{}, false
+	}
+	return h.entries[0].Time, true
+}
+
+func (h *History) dropOldest() {
+	if len(h.entries) > 0 {
+		h.entries = h.entries[1:]
+	}
+}
+
+// nearest returns the entry closest to t, provided it lies within tolerance.
+func (h *History) nearest(t time.Time, tolerance time.Duration) (HistoryEntry, bool) {
+	entries := h.entries
+	i := sort.Search(len(entries), func(i int) bool { return !entries[i].Time.Before(t) })
+
+	best, found := HistoryEntry{}, false
+	for _, j := range []int{i - 1, i} {
+		if j < 0 || j >= len(entries) {
+			continue
+		}
+		if !found || absDuration(entries[j].Time.Sub(t)) < absDuration(best.Time.Sub(t)) {
+			best, found = entries[j], true
+		}
+	}
+	if !found || absDuration(best.Time.Sub(t)) > tolerance {
+		return HistoryEntry{}, false
+	}
+	return best, true
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
@@ -0,0 +1,26 @@
+package main
+
+import "time"
+
+// maxHoldSeconds caps a single hold so a typo can't freeze a whole ride.
+const maxHoldSeconds = 600
+
+// hold freezes distance, moving time and calorie accumulation for d, after
+// which counting resumes on its own. A zero duration ends any hold early.
+func (app *App) hold(d time.Duration) {
+	if d <= 0 {
+		app.holdUntil.Store(0)
+		return
+	}
+	app.holdUntil.Store(time.Now().Add(d).UnixNano())
+}
+
+// holdRemaining returns how long the current hold has left, or zero when
+// not holding.
+func (app *App) holdRemaining(now time.Time) time.Duration {
+	until := app.holdUntil.Load()
+	if until == 0 {
+		return 0
+	}
+	return max(0, time.Unix(0, until).Sub(now))
+}
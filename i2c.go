@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// i2cSlave is the i2c-dev ioctl that binds a file descriptor to a 7-bit
+// device address.
+const i2cSlave = 0x0703
+
+// i2cDevice is a single device on a Linux i2c-dev bus such as /dev/i2c-1.
+type i2cDevice struct {
+	file *os.File
+}
+
+func openI2C(bus string, addr int) (*i2cDevice, error) {
+	file, err := os.OpenFile(bus, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.IoctlSetInt(int(file.Fd()), i2cSlave, addr); err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("select address 0x%02x on %s: %w", addr, bus, err)
+	}
+	return &i2cDevice{file: file}, nil
+}
+
+func (d *i2cDevice) Read(p []byte) (int, error)  { return d.file.Read(p) }
+func (d *i2cDevice) Write(p []byte) (int, error) { return d.file.Write(p) }
+func (d *i2cDevice) Close() error                { return d.file.Close() }
+
+// readRegister16 reads a big-endian 16-bit register.
+func (d *i2cDevice) readRegister16(reg byte) (uint16, error) {
+	if _, err := d.Write([]byte{reg}); err != nil {
+		return 0, err
+	}
+	buf := make([]byte, 2)
+	if _, err := d.Read(buf); err != nil {
+		return 0, err
+	}
+	return uint16(buf[0])<<8 | uint16(buf[1]), nil
+}
@@ -1,18 +1,38 @@
 package main
 
 import (
+	"context"
 	_ "embed"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"math"
+	"net"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
+	"unicode"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/limiter"
 	"github.com/warthog618/go-gpiocdev"
 )
 
+const (
+	PulseModeWheel  = "wheel"
+	PulseModeStroke = "stroke"
+)
+
+// edges closer together than this are contact bounce
+const debounceInterval = 10 * time.Millisecond
+
 type Config struct {
 	ChipName              string
 	LineOffset            int
@@ -20,18 +40,478 @@ type Config struct {
 	HttpPort              string
 	BodyWeightKilograms   float64
 	IdleTimeoutSeconds    float64
+
+	// LineChipName puts the speed line on its own gpiochip (e.g. an
+	// expander); empty uses ChipName.
+	LineChipName string
+
+	// PulseMode is "wheel" (one pulse per wheel revolution) or "stroke"
+	// (one pulse per stroke on a rower-style machine).
+	PulseMode      string
+	MetresPerPulse float64
+
+	// SplitDistanceMetres is the distance tracked for the best split
+	// (1000 for the fastest kilometre). Zero disables it.
+	SplitDistanceMetres float64
+
+	// UploadEndpoint receives each finished session as a JSON POST. Empty
+	// disables uploads.
+	UploadEndpoint     string
+	UploadRetrySeconds float64
+	// UploadSpoolDir keeps undelivered sessions on disk across restarts;
+	// UploadSpoolMax bounds how many are kept. Empty dir spools in memory.
+	UploadSpoolDir string
+	UploadSpoolMax int
+
+	// Timezone is an IANA name such as "Europe/Berlin"; empty means the
+	// system's local time.
+	Timezone string
+	// DailyResetTime ("HH:MM" in Timezone) ends the session once a day.
+	// Empty disables it.
+	DailyResetTime string
+	// DailySummaryPath receives one JSON line of the day's totals at each
+	// daily reset. Empty disables it.
+	DailySummaryPath string
+
+	// JoulesPerMetre drives a distance-based energy estimate reported next
+	// to the MET kcal. Zero omits it.
+	JoulesPerMetre float64
+
+	// History keeps one stats snapshot every HistoryIntervalSeconds, up to
+	// HistorySize entries, for looking up past values.
+	HistorySize             int
+	HistoryIntervalSeconds  float64
+	HistoryToleranceSeconds float64
+
+	// MaxInMemorySamples caps the combined number of entries held across the
+	// per-session buffers, evicting the oldest first. It bounds memory on
+	// long unattended rides at the cost of losing the start of the ride from
+	// anything built from those buffers. Zero means no cap.
+	MaxInMemorySamples int
+
+	// Debug exposes the /api/v1/debug endpoints.
+	Debug bool
+
+	// HTTP server limits; zero values keep fiber's defaults.
+	HttpConcurrency        int
+	HttpDisableKeepalive   bool
+	HttpIdleTimeoutSeconds float64
+	HttpReadTimeoutSeconds float64
+
+	// NfcI2CBus enables a PN532 NFC reader (e.g. "/dev/i2c-1") that loads
+	// the bike ID and wheel circumference from tags. Empty disables it.
+	NfcI2CBus      string
+	NfcI2CAddress  int
+	NfcPollSeconds float64
+
+	// StatsTcpPort streams newline-delimited JSON stats over plain TCP.
+	// Empty disables it.
+	StatsTcpPort string
+
+	// CoastingModel lets reported speed decay after the last pulse, using
+	// a rolling deceleration plus a drag term proportional to v^2, rather
+	// than holding and then dropping to zero.
+	CoastingModel                           bool
+	CoastRollingDecelMetresPerSecondSquared float64
+	CoastDragPerMetre                       float64
+
+	// BatteryI2CBus enables a MAX17048-style fuel gauge for
+	// /api/v1/power. Empty disables it.
+	BatteryI2CBus      string
+	BatteryI2CAddress  int
+	BatteryPollSeconds float64
+
+	// CalorieCorrectionFactor scales the MET kcal to match a trusted
+	// reference such as a watch.
+	CalorieCorrectionFactor float64
+
+	// StatsRateLimitRequests caps /api/v1/stats polls per client IP per
+	// StatsRateLimitWindowSeconds. Zero means unlimited.
+	StatsRateLimitRequests      int
+	StatsRateLimitWindowSeconds float64
+
+	// IdleByIntervalGrowth flags a stop as soon as the wheel is slowing and
+	// no pulse has arrived for IdleIntervalGrowthFactor times the last
+	// interval, ahead of IdleTimeoutSeconds.
+	IdleByIntervalGrowth     bool
+	IdleIntervalGrowthFactor float64
+
+	// ResponseHeaders are set on every HTTP response, e.g.
+	// {"Cache-Control": "no-store"}.
+	ResponseHeaders map[string]string
+
+	// Sprints start above SprintEntryKmh and end below SprintExitKmh;
+	// shorter than SprintMinSeconds they are ignored. Zero entry disables.
+	SprintEntryKmh   float64
+	SprintExitKmh    float64
+	SprintMinSeconds float64
+
+	// SyslogAddress sends logs to syslog ("local", "udp://host:514" or
+	// "tcp://host:514") instead of stderr. Empty keeps stderr.
+	SyslogAddress string
+
+	// MaxSnapshotGapSeconds caps the wall time credited to moving time and
+	// kcal by a single tick. Zero disables the cap.
+	MaxSnapshotGapSeconds float64
+
+	// SnapshotTickSeconds is how often moving time and kcal accrue. Reading
+	// stats never accrues, so the numbers don't depend on who polls.
+	SnapshotTickSeconds float64
+
+	// KcalRampSeconds ramps MET from resting to the speed-based value over
+	// the first seconds of each moving period. Zero disables the ramp.
+	KcalRampSeconds float64
+
+	// ProfilesDir holds saved bike profiles (<name>.json) that can be
+	// switched to at runtime. Empty disables profiles.
+	ProfilesDir string
+
+	// SnapshotTimeoutMillis bounds how long /api/v1/stats waits for a
+	// snapshot before answering 503. Zero waits indefinitely.
+	SnapshotTimeoutMillis int
+
+	// Google Fit OAuth credentials; finished sessions are written to Google
+	// Fit when all three are set.
+	GoogleFitClientID     string
+	GoogleFitClientSecret string
+	GoogleFitRefreshToken string
+
+	// Finished rides are tagged with a RideType when ClassifyRides is set:
+	// interval at RideTypeIntervalSprints sprints or more, commute at
+	// RideTypeCommuteStopsPerKm or more, recovery below RideTypeRecoveryKmh
+	// moving average, endurance otherwise. Zero disables a rule.
+	ClassifyRides             bool
+	RideTypeIntervalSprints   int
+	RideTypeCommuteStopsPerKm float64
+	RideTypeRecoveryKmh       float64
+
+	// DiagSampleSeconds is how often /api/v1/diag samples the raw edge
+	// rate. Zero disables the endpoint.
+	DiagSampleSeconds float64
+
+	// SkipFirstPulseDistance makes the first pulse of a session only start
+	// timing, so a single bump while parking adds no distance.
+	SkipFirstPulseDistance bool
+
+	// SpeedWindowSize is how many recent pulse intervals speed is averaged
+	// over. Zero uses only the latest interval.
+	SpeedWindowSize int
+
+	// HealthCheckSensor makes /healthz fail when a ride in progress has had
+	// no pulse for HealthSensorWindowSeconds.
+	HealthCheckSensor         bool
+	HealthSensorWindowSeconds float64
+
+	// TrackpointIntervalSeconds is how often the session records a
+	// cumulative distance sample for /api/v1/export.tcx. Zero disables it.
+	TrackpointIntervalSeconds float64
+
+	// StaleAfterSeconds marks stats as Stale once the last pulse is older
+	// than this. Zero disables the flag.
+	StaleAfterSeconds float64
+
+	// ComputeAllCalorieModels reports every calorie model with the inputs
+	// it needs side by side in CalorieEstimates.
+	ComputeAllCalorieModels bool
+
+	// StdoutStatsNdjson prints a JSON stats line to stdout every
+	// StdoutStatsIntervalSeconds.
+	StdoutStatsNdjson          bool
+	StdoutStatsIntervalSeconds float64
+
+	// RestoreAlways lets /api/v1/session/restore replace a current session
+	// that has already ridden further than the finished one.
+	RestoreAlways bool
+
+	// QuadratureLineOffsets are the A and B channels of a two-phase
+	// encoder on LineChipName; a full cycle with B leading A counts one
+	// pulse (swap them to flip direction). Backward motion is tracked but
+	// never subtracts distance. Equal offsets, the default, use the single
+	// LineOffset line.
+	QuadratureLineOffsets [2]int
+
+	// MinMovingSecondsForAverages holds back averaged stats until the ride
+	// has been moving this long, so tiny denominators don't show noise.
+	MinMovingSecondsForAverages float64
+
+	// GpsCalibrationSamples GPS speed readings (POST /api/v1/gps-speed)
+	// are compared against wheel speed to suggest a circumference, which
+	// GpsAutoApplyCircumference applies. Zero disables the endpoint.
+	GpsCalibrationSamples     int
+	GpsAutoApplyCircumference bool
+
+	// SessionsDir stores sessions finished by a reset or shutdown for
+	// /api/v1/sessions, and a checkpoint of the running one every
+	// CheckpointSeconds in case of a crash. A checkpoint younger than
+	// ResumeWithinSeconds at startup is resumed, an older one is finished.
+	// Empty keeps everything in memory.
+	SessionsDir         string
+	CheckpointSeconds   float64
+	ResumeWithinSeconds float64
+
+	// StreamIntervalSeconds is how often /api/v1/stats/stream pushes stats
+	// between pulses.
+	StreamIntervalSeconds float64
+
+	// CadenceChipName and CadenceLineOffset add a crank sensor for
+	// cadence. Empty chip name disables it.
+	CadenceChipName   string
+	CadenceLineOffset int
+
+	// EstimatePower reports watts from speed with a rolling resistance and
+	// drag model. KcalModel picks "met" (default) or "power" for
+	// KiloCalories.
+	EstimatePower          bool
+	RollingResistanceCoeff float64
+	DragAreaSquareMetres   float64
+	BikeWeightKilograms    float64
+	KcalModel              string
+
+	// Strava OAuth credentials; finished rides are uploaded to Strava as
+	// FIT files when all three are set.
+	StravaClientID     string
+	StravaClientSecret string
+	StravaRefreshToken string
+}
+
+func (c Config) quadrature() bool {
+	return c.QuadratureLineOffsets[0] != c.QuadratureLineOffsets[1]
+}
+
+// allOrNone reports whether the values are either all set or all empty.
+func allOrNone(values ...string) bool {
+	set := 0
+	for _, v := range values {
+		if v != "" {
+			set++
+		}
+	}
+	return set == 0 || set == len(values)
+}
+
+// chipFor returns the gpiochip for a sensor line, defaulting to ChipName.
+func (c Config) chipFor(lineChip string) string {
+	if lineChip != "" {
+		return lineChip
+	}
+	return c.ChipName
+}
+
+// validHeaderName reports whether name is an RFC 7230 token.
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if r > unicode.MaxASCII || !(unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("!#$%&'*+-.^_`|~", r)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c Config) location() (*time.Location, error) {
+	if c.Timezone == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(c.Timezone)
+}
+
+func (c Config) validate() error {
+	if c.ChipName == "" {
+		return errors.New("ChipName must be set")
+	}
+	if c.HttpPort == "" {
+		return errors.New("HttpPort must be set")
+	}
+	if c.CircumferenceInMetres <= 0 {
+		return errors.New("CircumferenceInMetres must be positive")
+	}
+	if c.BodyWeightKilograms <= 0 {
+		return errors.New("BodyWeightKilograms must be positive")
+	}
+	if c.IdleTimeoutSeconds <= 0 {
+		return errors.New("IdleTimeoutSeconds must be positive")
+	}
+	switch c.PulseMode {
+	case "", PulseModeWheel:
+	case PulseModeStroke:
+		if c.MetresPerPulse <= 0 {
+			return errors.New("MetresPerPulse must be positive in stroke mode")
+		}
+	default:
+		return fmt.Errorf("unknown PulseMode %q", c.PulseMode)
+	}
+	if c.CalorieCorrectionFactor < 0.5 || c.CalorieCorrectionFactor > 1.5 {
+		return fmt.Errorf("CalorieCorrectionFactor %.2f must be between 0.5 and 1.5", c.CalorieCorrectionFactor)
+	}
+	if c.StatsRateLimitRequests < 0 || (c.StatsRateLimitRequests > 0 && c.StatsRateLimitWindowSeconds <= 0) {
+		return errors.New("stats rate limit needs a non-negative request count and a positive window")
+	}
+	if c.IdleByIntervalGrowth && c.IdleIntervalGrowthFactor <= 1 {
+		return errors.New("IdleIntervalGrowthFactor must be greater than 1")
+	}
+	for name := range c.ResponseHeaders {
+		if !validHeaderName(name) {
+			return fmt.Errorf("ResponseHeaders: invalid header name %q", name)
+		}
+	}
+	if c.SprintEntryKmh > 0 && (c.SprintExitKmh <= 0 || c.SprintExitKmh > c.SprintEntryKmh) {
+		return errors.New("SprintExitKmh must be positive and no higher than SprintEntryKmh")
+	}
+	if c.MaxSnapshotGapSeconds < 0 {
+		return errors.New("MaxSnapshotGapSeconds must not be negative")
+	}
+	if c.StreamIntervalSeconds <= 0 {
+		return errors.New("StreamIntervalSeconds must be positive")
+	}
+	if c.SnapshotTickSeconds <= 0 {
+		return errors.New("SnapshotTickSeconds must be positive")
+	}
+	if c.KcalRampSeconds < 0 {
+		return errors.New("KcalRampSeconds must not be negative")
+	}
+	if c.SnapshotTimeoutMillis < 0 {
+		return errors.New("SnapshotTimeoutMillis must not be negative")
+	}
+	if c.HttpConcurrency < 0 || c.HttpIdleTimeoutSeconds < 0 || c.HttpReadTimeoutSeconds < 0 {
+		return errors.New("HTTP limits must not be negative")
+	}
+	if c.UploadEndpoint != "" && c.UploadRetrySeconds <= 0 {
+		return errors.New("UploadRetrySeconds must be positive when UploadEndpoint is set")
+	}
+	if c.SessionsDir != "" && c.CheckpointSeconds <= 0 {
+		return errors.New("CheckpointSeconds must be positive when SessionsDir is set")
+	}
+	if c.UploadSpoolMax < 0 {
+		return errors.New("UploadSpoolMax must not be negative")
+	}
+	if c.CoastRollingDecelMetresPerSecondSquared < 0 || c.CoastDragPerMetre < 0 {
+		return errors.New("coasting coefficients must not be negative")
+	}
+	if c.CoastingModel && c.CoastRollingDecelMetresPerSecondSquared == 0 && c.CoastDragPerMetre == 0 {
+		return errors.New("CoastingModel needs a rolling deceleration or drag coefficient")
+	}
+	if c.BatteryI2CBus != "" && c.BatteryPollSeconds <= 0 {
+		return errors.New("BatteryPollSeconds must be positive when BatteryI2CBus is set")
+	}
+	if c.NfcI2CBus != "" && c.NfcPollSeconds <= 0 {
+		return errors.New("NfcPollSeconds must be positive when NfcI2CBus is set")
+	}
+	if _, err := c.location(); err != nil {
+		return fmt.Errorf("Timezone: %w", err)
+	}
+	if c.DailySummaryPath != "" && c.DailyResetTime == "" {
+		return errors.New("DailySummaryPath needs DailyResetTime")
+	}
+	if c.DailyResetTime != "" {
+		if _, err := time.Parse("15:04", c.DailyResetTime); err != nil {
+			return fmt.Errorf("DailyResetTime must be HH:MM: %w", err)
+		}
+	}
+	if c.HealthCheckSensor && c.HealthSensorWindowSeconds <= 0 {
+		return errors.New("HealthSensorWindowSeconds must be positive when HealthCheckSensor is set")
+	}
+	if c.StdoutStatsNdjson && c.StdoutStatsIntervalSeconds <= 0 {
+		return errors.New("StdoutStatsIntervalSeconds must be positive when StdoutStatsNdjson is set")
+	}
+	if c.GpsCalibrationSamples < 0 {
+		return errors.New("GpsCalibrationSamples must not be negative")
+	}
+	switch c.KcalModel {
+	case "", KcalModelMet:
+	case KcalModelPower:
+		if !c.EstimatePower {
+			return errors.New("KcalModel power needs EstimatePower")
+		}
+	default:
+		return fmt.Errorf("KcalModel must be %q or %q", KcalModelMet, KcalModelPower)
+	}
+	if c.EstimatePower && (c.RollingResistanceCoeff < 0 || c.DragAreaSquareMetres < 0 || c.BikeWeightKilograms < 0) {
+		return errors.New("RollingResistanceCoeff, DragAreaSquareMetres and BikeWeightKilograms must not be negative")
+	}
+	if c.SpeedWindowSize < 0 {
+		return errors.New("SpeedWindowSize must not be negative")
+	}
+	if !allOrNone(c.GoogleFitClientID, c.GoogleFitClientSecret, c.GoogleFitRefreshToken) {
+		return errors.New("GoogleFitClientID, GoogleFitClientSecret and GoogleFitRefreshToken must be set together")
+	}
+	if !allOrNone(c.StravaClientID, c.StravaClientSecret, c.StravaRefreshToken) {
+		return errors.New("StravaClientID, StravaClientSecret and StravaRefreshToken must be set together")
+	}
+	return nil
 }
 
 type Session struct {
-	TotalRevolutions      uint64
+	// ID is the start time in epoch seconds, bumped when needed so no two
+	// sessions share one
+	ID                    int64
 	StartTimeEpochSeconds int64
-	LastTimestamp         time.Duration
 	LastInterval          time.Duration
 
 	LastPulseWall time.Time
 	LastCalcWall  time.Time
 	MovingSeconds float64
 	KiloCalories  float64
+
+	// ring of the most recent pulse timestamps, enough to span one split;
+	// SplitRingSize is the length it was built for
+	SplitPulses   []time.Duration
+	SplitNext     int
+	SplitRingSize int
+	BestSplit     time.Duration
+
+	History History
+
+	// set from an NFC bike tag; zero circumference means use the config
+	BikeID                string
+	CircumferenceInMetres float64
+
+	// distance of the first BankedPulses pulses, fixed when the pulse
+	// distance last changed so earlier pulses keep the old one
+	BankedMetres float64
+	BankedPulses uint64
+
+	CalorieGoalKcal float64
+
+	// WasMoving is the moving state last seen by onEdge or accrue, used
+	// to count moving -> stopped transitions.
+	WasMoving   bool
+	StopCount   int
+	MovingSince time.Time
+
+	// Decelerating is set when the latest interval grew over the previous
+	// one; StoppedEarly holds an interval-growth stop until the next pulse.
+	Decelerating bool
+	StoppedEarly bool
+
+	Sprints     []Sprint
+	SprintStart time.Time
+	SprintLast  time.Time
+	SprintPeak  float64
+
+	// at most one target is set at a time
+	TargetSpeedKmh float64
+	TargetWatts    float64
+
+	// smoothed m/s^2 from consecutive intervals
+	Acceleration float64
+
+	// ring of recent pulse intervals that speed is averaged over
+	Intervals    []time.Duration
+	IntervalNext int
+
+	// cumulative distance samples for TCX export
+	Track Track
+
+	Tags []string
+
+	// kcal by each model; KiloCalories follows the configured one
+	MetKiloCalories   float64
+	PowerKiloCalories float64
+
+	CadenceInterval time.Duration
+	LastCadenceWall time.Time
 }
 
 type Stats struct {
@@ -41,6 +521,45 @@ type Stats struct {
 	StartTimeEpochSeconds  int64   `json:"startTimeEpochSeconds"`
 	MovingMinutes          float64 `json:"movingMinutes"`
 	KiloCalories           float64 `json:"kiloCalories"`
+	// set in stroke mode, even at zero, and nil otherwise
+	TotalStrokes         *uint64  `json:"totalStrokes,omitempty"`
+	StrokeRate           *float64 `json:"strokeRate,omitempty"`
+	BestKilometreSeconds float64  `json:"bestKilometreSeconds,omitempty"`
+
+	KiloCaloriesFromDistance           float64            `json:"kiloCaloriesFromDistance,omitempty"`
+	BikeId                             string             `json:"bikeId,omitempty"`
+	CalorieGoalKcal                    float64            `json:"calorieGoalKcal,omitempty"`
+	CaloriesRemaining                  float64            `json:"caloriesRemaining,omitempty"`
+	CalorieGoalReached                 bool               `json:"calorieGoalReached,omitempty"`
+	StopCount                          int                `json:"stopCount"`
+	TargetSpeedKmh                     float64            `json:"targetSpeedKmh,omitempty"`
+	TargetWatts                        float64            `json:"targetWatts,omitempty"`
+	TargetDeltaPercent                 *float64           `json:"targetDeltaPercent,omitempty"`
+	AccelerationMetresPerSecondSquared float64            `json:"accelerationMetresPerSecondSquared"`
+	OnHold                             bool               `json:"onHold,omitempty"`
+	HoldRemainingSeconds               float64            `json:"holdRemainingSeconds,omitempty"`
+	RideType                           string             `json:"rideType,omitempty"`
+	Tags                               []string           `json:"tags,omitempty"`
+	DataFreshnessSeconds               float64            `json:"dataFreshnessSeconds,omitempty"`
+	Stale                              bool               `json:"stale,omitempty"`
+	CalorieEstimates                   map[string]float64 `json:"calorieEstimates,omitempty"`
+	StartTimeIso8601                   string             `json:"startTimeIso8601"`
+	AverageSpeedKilometresPerHour      float64            `json:"averageSpeedKilometresPerHour,omitempty"`
+	SuggestedCircumferenceMetres       float64            `json:"suggestedCircumferenceMetres,omitempty"`
+	DailyCalorieGoalKcal               float64            `json:"dailyCalorieGoalKcal,omitempty"`
+	DailyKiloCalories                  float64            `json:"dailyKiloCalories,omitempty"`
+	DailyCaloriesRemaining             float64            `json:"dailyCaloriesRemaining,omitempty"`
+	DailyCalorieGoalReached            bool               `json:"dailyCalorieGoalReached,omitempty"`
+	CadenceRpm                         float64            `json:"cadenceRpm,omitempty"`
+	EstimatedWatts                     float64            `json:"estimatedWatts,omitempty"`
+}
+
+// SessionRecord is a finished session as handed to the uploader.
+type SessionRecord struct {
+	ID int64 `json:"id"`
+	Stats
+	EndTimeEpochSeconds int64        `json:"endTimeEpochSeconds"`
+	Track               []TrackPoint `json:"track,omitempty"`
 }
 
 type ApiResponse struct {
@@ -49,23 +568,115 @@ type ApiResponse struct {
 }
 
 type App struct {
+	// Config is read and replaced under the lock; live publishes the same
+	// config to code that runs without it.
 	Config  Config
+	live    atomic.Pointer[Config]
 	Session Session
-	Line    *gpiocdev.Line
-	guard   chan struct{}
+	// sensor line handles, set and cleared under the lock
+	Line *gpiocdev.Line
+	// both encoder channels, in place of Line in quadrature mode
+	Lines      *gpiocdev.Lines
+	quadrature atomic.Pointer[quadrature]
+
+	CadenceLine     *gpiocdev.Line
+	cadenceLastEdge atomic.Int64
+	guard           chan struct{}
+	// serialises profile switches, which reopen GPIO outside the lock
+	profileGuard chan struct{}
+
+	// pulse count and last edge timestamp (ns) are atomics touched by
+	// onEdge outside the lock
+	pulses   atomic.Uint64
+	lastEdge atomic.Int64
+	// wall clock (ns) until which pulses and accumulation are held
+	holdUntil atomic.Int64
+
+	// set from Config.Timezone; read and replaced under the lock
+	location      *time.Location
+	uploader      *Uploader
+	battery       *BatteryStatus
+	Daily         DailyTotals
+	ActiveProfile string
+	googleFit     *GoogleFit
+	// Google Fit and Strava uploads in flight, waited for on shutdown
+	uploads  sync.WaitGroup
+	edges    edgeCounters
+	edgeRate EdgeRate
+	previous *finishedSession
+	gpsCal   gpsCalibration
+	// kcal target across all of today's sessions; outlives daily resets
+	DailyCalorieGoalKcal float64
+	// newest session ID handed out, including those in the store
+	lastSessionID int64
+	store         *SessionStore
+	streams       *broadcaster
+	strava        *Strava
+	// simulated runs without GPIO; pulses come from runSimulation
+	simulated bool
 }
 
 func NewApp(cfg Config) *App {
-	return &App{
-		Config:  cfg,
-		Session: Session{StartTimeEpochSeconds: time.Now().Unix()},
-		guard:   make(chan struct{}, 1),
+	app := &App{
+		Config:       cfg,
+		guard:        make(chan struct{}, 1),
+		profileGuard: make(chan struct{}, 1),
 	}
+	app.live.Store(&cfg)
+	now := time.Now()
+	app.Session = Session{ID: app.newSessionID(now), StartTimeEpochSeconds: now.Unix()}
+	app.Daily = DailyTotals{Since: time.Now()}
+	app.location, _ = cfg.location()
+	if app.location == nil {
+		app.location = time.Local
+	}
+	app.streams = newBroadcaster()
+	if cfg.GoogleFitClientID != "" && cfg.GoogleFitClientSecret != "" && cfg.GoogleFitRefreshToken != "" {
+		app.googleFit = NewGoogleFit(cfg.GoogleFitClientID, cfg.GoogleFitClientSecret, cfg.GoogleFitRefreshToken)
+	}
+	if cfg.StravaClientID != "" && cfg.StravaClientSecret != "" && cfg.StravaRefreshToken != "" {
+		app.strava = NewStrava(cfg.StravaClientID, cfg.StravaClientSecret, cfg.StravaRefreshToken)
+	}
+	if cfg.UploadEndpoint != "" {
+		app.uploader = NewUploader(cfg.UploadEndpoint, seconds(cfg.UploadRetrySeconds), cfg.UploadSpoolDir, cfg.UploadSpoolMax)
+		if err := app.uploader.loadSpool(); err != nil {
+			log.Printf("upload: spool: %v", err)
+		}
+	}
+	return app
 }
 
 func (app *App) lock()   { app.guard <- struct{}{} }
 func (app *App) unlock() { <-app.guard }
 
+// newSessionID returns now in epoch seconds, or one past the last ID handed
+// out if that is no later, so sessions started within the same second
+// still get their own. Caller must hold the lock, or be the only one with
+// the app.
+func (app *App) newSessionID(now time.Time) int64 {
+	id := max(now.Unix(), app.lastSessionID+1)
+	app.lastSessionID = id
+	return id
+}
+
+// currentLocation is the timezone stats and daily resets use, which a
+// profile switch may change.
+func (app *App) currentLocation() *time.Location {
+	app.lock()
+	defer app.unlock()
+	return app.location
+}
+
+// lockContext is lock(), giving up when ctx is done.
+func (app *App) lockContext(ctx context.Context) error {
+	select {
+	case app.guard <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func metFromSpeed(speedKmh float64) float64 {
 	switch {
 	case speedKmh < 10:
@@ -83,77 +694,528 @@ func metFromSpeed(speedKmh float64) float64 {
 	}
 }
 
+// metresPerPulse is the distance covered by a single pulse in the
+// configured mode.
+func (app *App) metresPerPulse() float64 {
+	if app.Config.PulseMode == PulseModeStroke {
+		return app.Config.MetresPerPulse
+	}
+	if app.Session.CircumferenceInMetres > 0 {
+		return app.Session.CircumferenceInMetres
+	}
+	return app.Config.CircumferenceInMetres
+}
+
+// distanceMetres is the distance covered by the session's first pulses,
+// each at the pulse distance in effect when it was counted. Caller must
+// hold the lock.
+func (app *App) distanceMetres(pulses uint64) float64 {
+	s := &app.Session
+	return s.BankedMetres + float64(pulses-s.BankedPulses)*app.metresPerPulse()
+}
+
+// bankDistance fixes the distance ridden so far ahead of a change to the
+// pulse distance, so only later pulses are measured with the new one.
+// Caller must hold the lock.
+func (app *App) bankDistance() {
+	pulses := app.pulses.Load()
+	app.Session.BankedMetres = app.distanceMetres(pulses)
+	app.Session.BankedPulses = pulses
+}
+
 func (app *App) onEdge(event gpiocdev.LineEvent) {
-	if event.Type != gpiocdev.LineEventFallingEdge {
+	if event.Type == gpiocdev.LineEventRisingEdge {
+		app.edges.rising.Add(1)
 		return
 	}
+	app.edges.falling.Add(1)
+	app.countPulse(event.Timestamp, debounceInterval)
+}
 
-	eventTimestamp := event.Timestamp
+// countPulse records one pulse at eventTimestamp, dropping it as bounce if
+// it follows the previous one within debounce.
+func (app *App) countPulse(eventTimestamp, debounce time.Duration) {
+	// Debounce and count without the lock so the kernel event callback
+	// stays cheap at high pulse rates; only the interval math below locks.
+	previous := time.Duration(app.lastEdge.Swap(int64(eventTimestamp)))
+	if previous > 0 && eventTimestamp-previous <= debounce {
+		return
+	}
+	// on hold the pulse still counts as movement, so the stop logic doesn't
+	// see a gap once the hold ends, but adds no distance
+	held := app.holdRemaining(time.Now()) > 0
+	if !held && (previous > 0 || !app.currentConfig().SkipFirstPulseDistance) {
+		app.pulses.Add(1)
+	}
 
 	app.lock()
 	defer app.unlock()
 
-	if app.Session.LastTimestamp > 0 {
-		dt := eventTimestamp - app.Session.LastTimestamp
-		if dt <= 10*time.Millisecond {
-			app.Session.LastTimestamp = eventTimestamp
+	if previous > 0 {
+		dt := eventTimestamp - previous
+		if dt.Seconds() < app.Config.IdleTimeoutSeconds {
+			app.Session.Decelerating = app.Session.LastInterval > 0 && dt > app.Session.LastInterval
+			app.updateAcceleration(app.Session.LastInterval, dt)
+			app.Session.LastInterval = dt
+			app.pushInterval(dt)
+			app.trackSprint(time.Now(), app.metresPerPulse()*3.6e9/float64(dt.Nanoseconds()))
+		} else {
+			// resuming after a stop: the gap says nothing about current speed
+			app.Session.LastInterval = 0
+			app.Session.Intervals, app.Session.IntervalNext = app.Session.Intervals[:0], 0
+			app.Session.Acceleration = 0
+			if app.Session.WasMoving {
+				// stopped and restarted between snapshots
+				app.Session.StopCount++
+			}
+			app.endSprint()
+		}
+	}
+	if !app.Session.WasMoving {
+		app.Session.MovingSince = time.Now()
+	}
+	app.Session.WasMoving = true
+	app.Session.StoppedEarly = false
+	app.Session.LastPulseWall = time.Now()
+	if !held {
+		app.recordSplitPulse(eventTimestamp)
+	}
+	app.streams.notify()
+}
+
+// pushInterval adds dt to the speed window, overwriting the oldest once
+// SpeedWindowSize intervals are held. Caller must hold the lock.
+func (app *App) pushInterval(dt time.Duration) {
+	n := app.Config.SpeedWindowSize
+	if n <= 0 {
+		return
+	}
+	s := &app.Session
+	if len(s.Intervals) > n {
+		// window shrank (e.g. a profile switch); start over
+		s.Intervals, s.IntervalNext = s.Intervals[:0], 0
+	}
+	if len(s.Intervals) < n {
+		s.Intervals = append(s.Intervals, dt)
+		return
+	}
+	s.Intervals[s.IntervalNext] = dt
+	s.IntervalNext = (s.IntervalNext + 1) % n
+}
+
+// smoothedInterval is the mean of the speed window, or the latest interval
+// when smoothing is off. Caller must hold the lock.
+func (app *App) smoothedInterval() time.Duration {
+	s := &app.Session
+	if len(s.Intervals) == 0 {
+		return s.LastInterval
+	}
+	var sum time.Duration
+	for _, dt := range s.Intervals {
+		sum += dt
+	}
+	return sum / time.Duration(len(s.Intervals))
+}
+
+// accelerationSmoothing is the EMA weight given to each new acceleration
+// sample.
+const accelerationSmoothing = 0.3
+
+// updateAcceleration folds the speed change between two consecutive
+// intervals into the smoothed acceleration. Caller must hold the lock.
+func (app *App) updateAcceleration(previous, current time.Duration) {
+	if previous <= 0 {
+		return
+	}
+	metresPerPulse := app.metresPerPulse()
+	v1 := metresPerPulse / previous.Seconds()
+	v2 := metresPerPulse / current.Seconds()
+	// the two speeds are averages over their intervals, so they sit half
+	// an interval each from the pulse between them
+	a := (v2 - v1) / ((previous + current).Seconds() / 2)
+	app.Session.Acceleration = accelerationSmoothing*a + (1-accelerationSmoothing)*app.Session.Acceleration
+}
+
+// recordSplitPulse keeps the timestamps of the last pulses needed to cover
+// SplitDistanceMetres and updates the best (shortest) time seen for it.
+// Caller must hold the lock.
+func (app *App) recordSplitPulse(ts time.Duration) {
+	metresPerPulse := app.metresPerPulse()
+	if app.Config.SplitDistanceMetres <= 0 || metresPerPulse <= 0 {
+		return
+	}
+	n := int(math.Ceil(app.Config.SplitDistanceMetres/metresPerPulse)) + 1
+
+	s := &app.Session
+	if s.SplitRingSize != n {
+		// pulse or split distance changed (e.g. a new bike tag); start the
+		// ring over, since a wrapped ring can't grow or shrink in order
+		s.SplitPulses, s.SplitNext, s.SplitRingSize = s.SplitPulses[:0], 0, n
+	}
+	if len(s.SplitPulses) < n {
+		s.SplitPulses = append(s.SplitPulses, ts)
+		if len(s.SplitPulses) < n {
 			return
 		}
-		app.Session.LastInterval = dt
-		app.Session.TotalRevolutions++
+		s.SplitNext = 0
 	} else {
-		// first ever pulse
-		app.Session.TotalRevolutions++
+		s.SplitPulses[s.SplitNext] = ts
+		s.SplitNext = (s.SplitNext + 1) % n
+	}
+
+	split := ts - s.SplitPulses[s.SplitNext]
+	if s.BestSplit == 0 || split < s.BestSplit {
+		s.BestSplit = split
 	}
-	app.Session.LastTimestamp = eventTimestamp
-	app.Session.LastPulseWall = time.Now()
 }
 
 func (app *App) snapshot() Stats {
 	app.lock()
 	defer app.unlock()
+	return app.statsLocked(time.Now())
+}
 
-	now := time.Now()
+// snapshotWithin is snapshot() bounded by timeout, covering both waiting for
+// the lock and the computation itself. A computation that overruns still
+// completes (and releases the lock) in the background; its result is
+// dropped.
+func (app *App) snapshotWithin(timeout time.Duration) (Stats, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := app.lockContext(ctx); err != nil {
+		return Stats{}, err
+	}
+	result := make(chan Stats, 1)
+	go func() {
+		defer app.unlock()
+		result <- app.statsLocked(time.Now())
+	}()
+	select {
+	case stats := <-result:
+		return stats, nil
+	case <-ctx.Done():
+		return Stats{}, ctx.Err()
+	}
+}
+
+// motion is the moving state and speed the session shows at a given time.
+type motion struct {
+	moving bool
+	// slowing down and the next pulse well overdue
+	stoppedEarly bool
+	// idle past the timeout with no coasting speed left, so the last
+	// interval is stale
+	idle         bool
+	speedKmh     float64
+	pulsesPerMin float64
+}
+
+// motionAt works out the moving state and speed at now without changing
+// the session. Caller must hold the lock.
+func (app *App) motionAt(now time.Time) motion {
+	var m motion
+	if !app.Session.LastPulseWall.IsZero() {
+		if now.Sub(app.Session.LastPulseWall).Seconds() < app.Config.IdleTimeoutSeconds {
+			m.moving = true
+		}
+	}
+	if m.moving && app.Config.IdleByIntervalGrowth {
+		// Slowing down and the next pulse is well overdue: call it a stop
+		// now rather than waiting out the full idle timeout.
+		overdue := app.Session.LastInterval > 0 &&
+			now.Sub(app.Session.LastPulseWall).Seconds() > app.Config.IdleIntervalGrowthFactor*app.Session.LastInterval.Seconds()
+		m.stoppedEarly = app.Session.StoppedEarly || (app.Session.Decelerating && overdue)
+		m.moving = !m.stoppedEarly
+	}
+
+	// Speed (and stroke rate) from the mean of the recent intervals
+	if interval := app.smoothedInterval(); interval > 0 {
+		dtNs := float64(interval.Nanoseconds())
+		m.speedKmh = app.metresPerPulse() * 3.6e9 / dtNs
+		m.pulsesPerMin = 60e9 / dtNs
+	}
+	if app.Config.CoastingModel && m.speedKmh > 0 {
+		// spin down from the last measured speed instead of holding it
+		sinceLastPulse := now.Sub(app.Session.LastPulseWall).Seconds()
+		m.speedKmh = 3.6 * coastSpeed(m.speedKmh/3.6, sinceLastPulse,
+			app.Config.CoastRollingDecelMetresPerSecondSquared, app.Config.CoastDragPerMetre)
+	}
+	if !m.moving {
+		m.pulsesPerMin = 0
+		if m.speedKmh == 0 || !app.Config.CoastingModel {
+			// idle past the timeout: speed reads 0 now instead of showing a
+			// ghost speed
+			m.idle = true
+			m.speedKmh = 0
+		}
+	}
+	return m
+}
+
+// accrue advances moving time and kcal to now, settles stops and sprints,
+// and records history and export samples. Only the snapshot ticker and
+// reset call it, so how often clients read stats never changes them.
+func (app *App) accrue() {
+	app.lock()
+	defer app.unlock()
+	app.accrueLocked(time.Now())
+}
+
+// accrueLocked is accrue() and returns the stats it recorded. Caller must
+// hold the lock.
+func (app *App) accrueLocked(now time.Time) Stats {
 	dtWall := 0.0
 	if !app.Session.LastCalcWall.IsZero() {
 		dtWall = now.Sub(app.Session.LastCalcWall).Seconds()
 	}
 	app.Session.LastCalcWall = now
-
-	// Distance
-	distanceKm := float64(app.Session.TotalRevolutions) * app.Config.CircumferenceInMetres / 1000.0
-
-	// Instantaneous speed from last interval
-	var speedKmh float64
-	if app.Session.LastInterval > 0 {
-		dtNs := float64(app.Session.LastInterval.Nanoseconds())
-		speedKmh = app.Config.CircumferenceInMetres * 3.6e9 / dtNs
+	// a clock jump or a stalled ticker must not be integrated as one huge
+	// chunk of riding
+	if dtWall < 0 {
+		dtWall = 0
+	}
+	if limit := app.Config.MaxSnapshotGapSeconds; limit > 0 && dtWall > limit {
+		dtWall = limit
 	}
 
-	// Moving?
-	moving := false
-	if !app.Session.LastPulseWall.IsZero() {
-		if time.Since(app.Session.LastPulseWall).Seconds() < app.Config.IdleTimeoutSeconds {
-			moving = true
-		}
+	holdLeft := app.holdRemaining(now)
+	m := app.motionAt(now)
+	if m.stoppedEarly {
+		// held until the next pulse
+		app.Session.StoppedEarly = true
+	}
+	// A stop only counts once the idle timeout has passed, so coasting
+	// between pulses doesn't register as one.
+	if !m.moving && app.Session.WasMoving && holdLeft == 0 {
+		app.Session.StopCount++
+		app.Session.WasMoving = false
+	}
+	if !m.moving {
+		app.endSprint()
+	}
+	if m.idle {
+		// the next pulse starts fresh
+		app.Session.LastInterval = 0
+		app.Session.Intervals, app.Session.IntervalNext = app.Session.Intervals[:0], 0
 	}
 
 	// Update kcal + moving time only if moving
-	if moving && dtWall > 0 {
-		met := metFromSpeed(speedKmh)
-		kcalPerMin := (met * 3.5 * app.Config.BodyWeightKilograms) / 200.0
-		app.Session.KiloCalories += kcalPerMin * (dtWall / 60.0)
+	if m.moving && dtWall > 0 && holdLeft == 0 {
+		met := app.Config.rampedMet(metFromSpeed(m.speedKmh), now.Sub(app.Session.MovingSince).Seconds())
+		app.Session.MetKiloCalories += app.Config.kcalPerMinuteAtMet(met) * (dtWall / 60.0)
+		if app.Config.EstimatePower {
+			watts := app.Config.estimateWatts(m.speedKmh)
+			app.Session.PowerKiloCalories += app.Config.kcalPerMinuteAtWatts(watts) * (dtWall / 60.0)
+		}
+		if app.Config.KcalModel == KcalModelPower {
+			app.Session.KiloCalories = app.Session.PowerKiloCalories
+		} else {
+			app.Session.KiloCalories = app.Session.MetKiloCalories
+		}
 		app.Session.MovingSeconds += dtWall
 	}
 
-	return Stats{
+	stats := app.statsLocked(now)
+	app.recordHistory(now, stats)
+	app.recordTrackPoint(now, stats)
+	return stats
+}
+
+// statsLocked builds the stats at now from the session as last accrued. It
+// changes nothing, so any number of readers see the same numbers. Caller
+// must hold the lock.
+func (app *App) statsLocked(now time.Time) Stats {
+	// Distance
+	pulses := app.pulses.Load()
+	distanceKm := app.distanceMetres(pulses) / 1000.0
+
+	holdLeft := app.holdRemaining(now)
+	m := app.motionAt(now)
+	speedKmh := m.speedKmh
+
+	stats := Stats{
 		SpeedKilometresPerHour: round(speedKmh, 2),
-		TotalRevolutions:       app.Session.TotalRevolutions,
+		TotalRevolutions:       pulses,
 		DistanceKilometres:     round(distanceKm, 3),
 		StartTimeEpochSeconds:  app.Session.StartTimeEpochSeconds,
+		StartTimeIso8601:       time.Unix(app.Session.StartTimeEpochSeconds, 0).In(app.location).Format(time.RFC3339),
 		MovingMinutes:          round(app.Session.MovingSeconds/60.0, 2),
 		KiloCalories:           round(app.Session.KiloCalories, 1),
+		BestKilometreSeconds:   round(app.Session.BestSplit.Seconds(), 1),
+		BikeId:                 app.Session.BikeID,
+		StopCount:              app.Session.StopCount,
+		Tags:                   slices.Clone(app.Session.Tags),
+	}
+	if goal := app.Session.CalorieGoalKcal; goal > 0 {
+		stats.CalorieGoalKcal = goal
+		stats.CaloriesRemaining = round(math.Max(0, goal-app.Session.KiloCalories), 1)
+		stats.CalorieGoalReached = app.Session.KiloCalories >= goal
+	}
+	if goal := app.DailyCalorieGoalKcal; goal > 0 {
+		// finished sessions today plus this one so far
+		today := app.Daily.KiloCalories + app.Session.KiloCalories
+		stats.DailyCalorieGoalKcal = goal
+		stats.DailyKiloCalories = round(today, 1)
+		stats.DailyCaloriesRemaining = round(math.Max(0, goal-today), 1)
+		stats.DailyCalorieGoalReached = today >= goal
+	}
+	if m.moving && app.Session.LastInterval > 0 {
+		stats.AccelerationMetresPerSecondSquared = round(app.Session.Acceleration, 2)
+	}
+	if target := app.Session.TargetSpeedKmh; target > 0 {
+		delta := round((speedKmh-target)/target*100.0, 1)
+		stats.TargetSpeedKmh = target
+		stats.TargetDeltaPercent = &delta
+	}
+	if target := app.Session.TargetWatts; target > 0 && app.Config.EstimatePower {
+		delta := round((app.Config.estimateWatts(speedKmh)-target)/target*100.0, 1)
+		stats.TargetWatts = target
+		stats.TargetDeltaPercent = &delta
+	}
+	if moved := app.Session.MovingSeconds; moved > 0 && moved >= app.Config.MinMovingSecondsForAverages {
+		stats.AverageSpeedKilometresPerHour = round(distanceKm/(moved/3600.0), 2)
+	}
+	stats.SuggestedCircumferenceMetres = app.suggestedCircumference()
+	if last := app.Session.LastPulseWall; !last.IsZero() {
+		age := now.Sub(last).Seconds()
+		stats.DataFreshnessSeconds = round(age, 1)
+		stats.Stale = app.Config.StaleAfterSeconds > 0 && age > app.Config.StaleAfterSeconds
+	}
+	if holdLeft > 0 {
+		stats.OnHold = true
+		stats.HoldRemainingSeconds = round(holdLeft.Seconds(), 1)
+	}
+	if app.Config.JoulesPerMetre > 0 {
+		stats.KiloCaloriesFromDistance = round(distanceKm*1000.0*app.Config.JoulesPerMetre/4184.0, 1)
+	}
+	if app.Config.CadenceChipName != "" {
+		stats.CadenceRpm = round(app.cadenceRpm(now), 1)
+	}
+	if app.Config.EstimatePower {
+		stats.EstimatedWatts = round(app.Config.estimateWatts(speedKmh), 0)
+	}
+	if app.Config.ComputeAllCalorieModels {
+		// there is no heart rate input; power needs EstimatePower and
+		// distance needs JoulesPerMetre
+		stats.CalorieEstimates = map[string]float64{"met": round(app.Session.MetKiloCalories, 1)}
+		if app.Config.EstimatePower {
+			stats.CalorieEstimates["power"] = round(app.Session.PowerKiloCalories, 1)
+		}
+		if app.Config.JoulesPerMetre > 0 {
+			stats.CalorieEstimates["distance"] = stats.KiloCaloriesFromDistance
+		}
+	}
+	if app.Config.PulseMode == PulseModeStroke {
+		stats.TotalRevolutions = 0
+		strokeRate := round(m.pulsesPerMin, 1)
+		stats.TotalStrokes, stats.StrokeRate = &pulses, &strokeRate
+	}
+	return stats
+}
+
+// pulseCount is the session's revolutions, or its strokes in stroke mode.
+func (s Stats) pulseCount() uint64 {
+	if s.TotalStrokes != nil {
+		return *s.TotalStrokes
 	}
+	return s.TotalRevolutions
+}
+
+// recordHistory appends stats to the session history if at least
+// HistoryIntervalSeconds have passed since the previous entry. Caller must
+// hold the lock.
+func (app *App) recordHistory(now time.Time, stats Stats) {
+	if last, ok := app.Session.History.last(); ok {
+		if now.Sub(last.Time).Seconds() < app.Config.HistoryIntervalSeconds {
+			return
+		}
+	}
+	app.Session.History.add(HistoryEntry{Time: now, Stats: stats}, app.Config.HistorySize)
+	app.enforceSampleBudget()
+}
+
+// setCalorieGoal sets the session's kcal target; zero clears it.
+func (app *App) setCalorieGoal(kcal float64) {
+	app.lock()
+	defer app.unlock()
+	app.Session.CalorieGoalKcal = kcal
+}
+
+// setDailyCalorieGoal sets the kcal target for the day; zero clears it.
+func (app *App) setDailyCalorieGoal(kcal float64) {
+	app.lock()
+	defer app.unlock()
+	app.DailyCalorieGoalKcal = kcal
+}
+
+// setTargetSpeed sets a speed pacing target in place of any other; zero
+// clears it.
+func (app *App) setTargetSpeed(kmh float64) {
+	app.lock()
+	defer app.unlock()
+	app.Session.TargetSpeedKmh, app.Session.TargetWatts = kmh, 0
+}
+
+// setTargetWatts sets a power pacing target in place of any other; zero
+// clears it.
+func (app *App) setTargetWatts(watts float64) {
+	app.lock()
+	defer app.unlock()
+	app.Session.TargetSpeedKmh, app.Session.TargetWatts = 0, watts
+}
+
+const (
+	maxSessionTags   = 10
+	maxSessionTagLen = 32
+)
+
+// setTags replaces the session's free-form tags.
+func (app *App) setTags(tags []string) error {
+	if len(tags) > maxSessionTags {
+		return fmt.Errorf("at most %d tags", maxSessionTags)
+	}
+	for _, tag := range tags {
+		if tag == "" || len(tag) > maxSessionTagLen {
+			return fmt.Errorf("tags must be 1 to %d bytes", maxSessionTagLen)
+		}
+	}
+	app.lock()
+	defer app.unlock()
+	app.Session.Tags = slices.Clone(tags)
+	return nil
+}
+
+// statsAt returns the recorded stats closest to t.
+func (app *App) statsAt(t time.Time) (Stats, bool) {
+	app.lock()
+	defer app.unlock()
+	entry, ok := app.Session.History.nearest(t, seconds(app.Config.HistoryToleranceSeconds))
+	return entry.Stats, ok
+}
+
+// coastSpeed is the speed t seconds after coasting from v0 (m/s) under
+// dv/dt = -(a0 + k*v^2): a constant rolling deceleration plus
+// air drag.
+func coastSpeed(v0, t, a0, k float64) float64 {
+	var v float64
+	switch {
+	case k <= 0:
+		v = v0 - a0*t
+	case a0 <= 0:
+		v = v0 / (1 + k*v0*t)
+	default:
+		c := math.Sqrt(a0 / k)
+		theta := math.Atan(v0/c) - math.Sqrt(a0*k)*t
+		if theta <= 0 {
+			return 0
+		}
+		v = c * math.Tan(theta)
+	}
+	return math.Max(0, v)
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
 }
 
 func round(v float64, places int) float64 {
@@ -165,12 +1227,185 @@ func round(v float64, places int) float64 {
 }
 
 func (a *App) reset() {
+	a.finishSession(time.Now(), true)
+}
+
+// finishSession stores and uploads the current session as ending at end,
+// then starts a new one. Only a live session, one ridden since startup,
+// counts toward today's totals and can be restored.
+func (a *App) finishSession(end time.Time, live bool) {
 	a.lock()
-	a.Session = Session{StartTimeEpochSeconds: time.Now().Unix()}
+	// close the books on the last partial tick
+	final := a.accrueLocked(time.Now())
+	// countPulse counts without the lock, so take off exactly the pulses in
+	// the final stats; any that land meanwhile go to the new session
+	pulses := final.pulseCount()
+	if a.Config.ClassifyRides && pulses > 0 {
+		final.RideType = a.classifyRide(final)
+	}
+	if live {
+		a.addSessionToDaily(pulses)
+		if pulses > 0 {
+			a.previous = &finishedSession{Session: a.Session, Pulses: pulses, DistanceMetres: a.distanceMetres(pulses), EndedAt: end}
+		}
+	}
+	if pulses > 0 {
+		a.pulses.Add(^(pulses - 1))
+	}
+	track, id := a.Session.Track.points, a.Session.ID
+	a.lastEdge.Store(0)
+	a.cadenceLastEdge.Store(0)
+	a.holdUntil.Store(0)
+	a.edges.clear()
+	a.edgeRate = EdgeRate{}
+	now := time.Now()
+	a.Session = Session{
+		ID:                    a.newSessionID(now),
+		StartTimeEpochSeconds: now.Unix(),
+		// still on the same bike
+		BikeID:                a.Session.BikeID,
+		CircumferenceInMetres: a.Session.CircumferenceInMetres,
+	}
 	a.unlock()
+
+	record := SessionRecord{ID: id, Stats: final, EndTimeEpochSeconds: end.Unix(), Track: track}
+	if a.store != nil {
+		if err := a.store.finish(record); err != nil {
+			log.Printf("sessions: %v", err)
+		}
+	}
+	if pulses == 0 {
+		return
+	}
+	if a.uploader != nil {
+		a.uploader.enqueue(record)
+	}
+	if a.googleFit != nil {
+		a.uploads.Add(1)
+		go func() {
+			defer a.uploads.Done()
+			a.googleFit.uploadAndLog(record)
+		}()
+	}
+	if a.strava != nil {
+		a.uploads.Add(1)
+		go func() {
+			defer a.uploads.Done()
+			a.strava.uploadAndLog(record)
+		}()
+	}
+}
+
+// waitForUploads gives uploads still in flight up to timeout to finish.
+func (a *App) waitForUploads(timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		a.uploads.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		log.Printf("shutdown: gave up waiting for uploads")
+	}
+}
+
+// resettableFields maps the fields accepted by a selective reset to what
+// they clear.
+var resettableFields = map[string]func(a *App){
+	"distance": func(a *App) {
+		a.pulses.Store(0)
+		a.Session.BankedMetres, a.Session.BankedPulses = 0, 0
+		a.Session.SplitPulses, a.Session.SplitNext, a.Session.BestSplit = nil, 0, 0
+		a.Session.Track = Track{}
+	},
+	"calories": func(a *App) {
+		a.Session.KiloCalories, a.Session.MetKiloCalories, a.Session.PowerKiloCalories = 0, 0, 0
+	},
+	"time":  func(a *App) { a.Session.MovingSeconds = 0 },
+	"stops": func(a *App) { a.Session.StopCount = 0 },
+}
+
+// resetFields clears only the named parts of the session, keeping the
+// session itself (and its start time) running.
+func (a *App) resetFields(fields []string) {
+	a.lock()
+	defer a.unlock()
+	a.accrueLocked(time.Now())
+	for _, name := range fields {
+		resettableFields[name](a)
+	}
+}
+
+// runSnapshotTicker advances the integration every SnapshotTickSeconds,
+// whether or not anyone is reading stats.
+func (a *App) runSnapshotTicker() {
+	ticker := time.NewTicker(seconds(a.currentConfig().SnapshotTickSeconds))
+	defer ticker.Stop()
+	for range ticker.C {
+		a.accrue()
+	}
+}
+
+// nextDailyReset returns the first hh:mm in loc strictly after now.
+func nextDailyReset(now time.Time, hhmm string, loc *time.Location) time.Time {
+	t, _ := time.Parse("15:04", hhmm)
+	now = now.In(loc)
+	next := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, loc)
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// runDailyReset ends the session every day at DailyResetTime. reset() hands
+// the finished session to the uploader before starting a new one; the day's
+// totals are then appended to DailySummaryPath. The next reset is worked
+// out again at least once a minute, so a profile switch to another
+// timezone or reset time takes effect the same day.
+func (a *App) runDailyReset() {
+	checked := time.Now()
+	for {
+		cfg := a.currentConfig()
+		next := nextDailyReset(checked, cfg.DailyResetTime, a.currentLocation())
+		time.Sleep(min(time.Until(next), time.Minute))
+		checked = time.Now()
+		if checked.Before(next) || a.currentConfig().DailyResetTime == "" {
+			continue
+		}
+		log.Printf("daily reset (%s)", cfg.DailyResetTime)
+		a.reset()
+
+		summary := a.rollDaily(time.Now())
+		if path := a.currentConfig().DailySummaryPath; path != "" {
+			if err := appendDailySummary(path, summary); err != nil {
+				log.Printf("daily summary: %v", err)
+			}
+		}
+	}
 }
 
 func (a *App) openGPIO() error {
+	if a.simulated {
+		return nil
+	}
+	if err := a.openWheel(); err != nil {
+		return err
+	}
+	if a.currentConfig().CadenceChipName != "" {
+		if err := a.openCadence(); err != nil {
+			a.closeGPIO()
+			return fmt.Errorf("cadence: %w", err)
+		}
+	}
+	return nil
+}
+
+func (a *App) openWheel() error {
+	cfg := a.currentConfig()
+	if cfg.quadrature() {
+		return a.openQuadrature()
+	}
 	options := []gpiocdev.LineReqOption{
 		gpiocdev.AsInput,
 		gpiocdev.WithPullUp,
@@ -179,34 +1414,85 @@ func (a *App) openGPIO() error {
 	}
 	options = append(options, gpiocdev.WithMonotonicEventClock)
 
-	line, err := gpiocdev.RequestLine(a.Config.ChipName, a.Config.LineOffset, options...)
+	line, err := gpiocdev.RequestLine(cfg.chipFor(cfg.LineChipName), cfg.LineOffset, options...)
 	if err != nil {
 		return err
 	}
+	a.lock()
 	a.Line = line
+	a.unlock()
 	return nil
 }
 
+// closeGPIO releases the sensor lines. The handles are taken under the lock
+// but closed outside it, since closing waits for the edge handlers and they
+// take the lock themselves.
 func (a *App) closeGPIO() {
-	if a.Line != nil {
-		_ = a.Line.Close()
+	a.lock()
+	line, lines, cadence := a.Line, a.Lines, a.CadenceLine
+	a.Line, a.Lines, a.CadenceLine = nil, nil, nil
+	a.unlock()
+	if line != nil {
+		_ = line.Close()
+	}
+	if lines != nil {
+		_ = lines.Close()
+	}
+	if cadence != nil {
+		_ = cadence.Close()
 	}
 }
 
 //go:embed index.html
 var indexHTML string
 
+// shutdownUploadTimeout bounds how long shutdown waits for the final
+// session's uploads.
+const shutdownUploadTimeout = 10 * time.Second
+
+// indexHTMLGzip is index.html precompressed by `make`; keep the two in sync.
+//
+//go:embed index.html.gz
+var indexHTMLGzip []byte
+
 func main() {
-	config := Config{
-		ChipName:              "gpiochip0",
-		LineOffset:            17,
-		CircumferenceInMetres: 1.41,
-		HttpPort:              "80",
-		BodyWeightKilograms:   85,
-		IdleTimeoutSeconds:    2.0,
+	configPath := flag.String("config", os.Getenv("VITAL_CONFIG"), "JSON or YAML config file")
+	simulateFlag := flag.Bool("simulate", false, "generate synthetic pulses instead of reading GPIO")
+	simulateProfile := flag.String("simulate-profile", defaultSimulateProfile, "repeating rpm:seconds segments for --simulate")
+	flag.Parse()
+
+	config, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	if err := config.validate(); err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	var profile []simSegment
+	if *simulateFlag {
+		profile, err = parseSimulateProfile(*simulateProfile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if config.SyslogAddress != "" {
+		if err := useSyslog(config.SyslogAddress); err != nil {
+			log.Printf("syslog: %v (logging to stderr)", err)
+		}
 	}
 
 	app := NewApp(config)
+	app.simulated = *simulateFlag
+	if config.SessionsDir != "" {
+		store, err := NewSessionStore(config.SessionsDir)
+		if err != nil {
+			log.Fatalf("sessions: %v", err)
+		}
+		if err := app.attachStore(store); err != nil {
+			log.Fatalf("sessions: %v", err)
+		}
+		app.resumeFromCheckpoint()
+	}
 	if err := app.openGPIO(); err != nil {
 		log.Fatalf("gpio: %v", err)
 	}
@@ -215,19 +1501,332 @@ func main() {
 	server := fiber.New(fiber.Config{
 		DisableStartupMessage: true,
 		AppName:               "vital",
+		Concurrency:           config.HttpConcurrency,
+		DisableKeepalive:      config.HttpDisableKeepalive,
+		IdleTimeout:           seconds(config.HttpIdleTimeoutSeconds),
+		ReadTimeout:           seconds(config.HttpReadTimeoutSeconds),
+	})
+
+	if len(config.ResponseHeaders) > 0 {
+		server.Use(func(c *fiber.Ctx) error {
+			for name, value := range config.ResponseHeaders {
+				c.Set(name, value)
+			}
+			return c.Next()
+		})
+	}
+
+	statsHandlers := []fiber.Handler{}
+	if config.StatsRateLimitRequests > 0 {
+		statsHandlers = append(statsHandlers, limiter.New(limiter.Config{
+			Max:        config.StatsRateLimitRequests,
+			Expiration: seconds(config.StatsRateLimitWindowSeconds),
+			LimitReached: func(c *fiber.Ctx) error {
+				return c.Status(fiber.StatusTooManyRequests).JSON(ApiResponse{Data: fiber.Map{}, Message: "too many requests"})
+			},
+		}))
+	}
+
+	server.Get("/api/v1/stats", append(statsHandlers, func(c *fiber.Ctx) error {
+		c.Vary(fiber.HeaderAccept)
+		if config.SnapshotTimeoutMillis <= 0 {
+			return sendNegotiated(c, ApiResponse{Data: app.snapshot(), Message: "ok"})
+		}
+		stats, err := app.snapshotWithin(time.Duration(config.SnapshotTimeoutMillis) * time.Millisecond)
+		if err != nil {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(ApiResponse{Data: fiber.Map{}, Message: "stats computation timed out"})
+		}
+		return sendNegotiated(c, ApiResponse{Data: stats, Message: "ok"})
+	})...)
+
+	server.Get("/api/v1/stats/stream", app.streamStats)
+
+	server.Get("/api/v1/stats/at", func(c *fiber.Ctx) error {
+		epoch, err := strconv.ParseFloat(c.Query("t"), 64)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(ApiResponse{Data: fiber.Map{}, Message: "t must be an epoch timestamp in seconds"})
+		}
+		stats, ok := app.statsAt(time.Unix(0, int64(epoch*1e9)))
+		if !ok {
+			return c.Status(fiber.StatusNotFound).JSON(ApiResponse{Data: fiber.Map{}, Message: "no stats recorded near that time"})
+		}
+		return c.JSON(ApiResponse{Data: stats, Message: "ok"})
+	})
+
+	server.Get("/api/v1/export.tcx", func(c *fiber.Ctx) error {
+		return sendExport(c, app.currentExport(), "tcx")
+	})
+
+	server.Get("/api/v1/calories/curve", func(c *fiber.Ctx) error {
+		return c.JSON(ApiResponse{Data: app.currentConfig().calorieCurve(), Message: "ok"})
+	})
+
+	server.Post("/api/v1/target", func(c *fiber.Ctx) error {
+		var body struct {
+			SpeedKmh *float64 `json:"speedKmh"`
+			Watts    *float64 `json:"watts"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(ApiResponse{Data: fiber.Map{}, Message: "expected {speedKmh} or {watts}"})
+		}
+		if body.Watts != nil {
+			if !app.currentConfig().EstimatePower {
+				return c.Status(fiber.StatusBadRequest).JSON(ApiResponse{Data: fiber.Map{}, Message: "power targets need EstimatePower"})
+			}
+			if *body.Watts < 0 {
+				return c.Status(fiber.StatusBadRequest).JSON(ApiResponse{Data: fiber.Map{}, Message: "expected {watts >= 0}"})
+			}
+			app.setTargetWatts(*body.Watts)
+			if *body.Watts == 0 {
+				return c.JSON(ApiResponse{Data: fiber.Map{}, Message: "target cleared"})
+			}
+			return c.JSON(ApiResponse{Data: fiber.Map{"watts": *body.Watts}, Message: "target set"})
+		}
+		if body.SpeedKmh == nil || *body.SpeedKmh < 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(ApiResponse{Data: fiber.Map{}, Message: "expected {speedKmh >= 0}"})
+		}
+		app.setTargetSpeed(*body.SpeedKmh)
+		if *body.SpeedKmh == 0 {
+			return c.JSON(ApiResponse{Data: fiber.Map{}, Message: "target cleared"})
+		}
+		return c.JSON(ApiResponse{Data: fiber.Map{"speedKmh": *body.SpeedKmh}, Message: "target set"})
+	})
+
+	server.Post("/api/v1/hold", func(c *fiber.Ctx) error {
+		var body struct {
+			Seconds *float64 `json:"seconds"`
+		}
+		if err := c.BodyParser(&body); err != nil || body.Seconds == nil || *body.Seconds < 0 || *body.Seconds > maxHoldSeconds {
+			return c.Status(fiber.StatusBadRequest).JSON(ApiResponse{Data: fiber.Map{}, Message: fmt.Sprintf("expected {seconds} between 0 and %d", maxHoldSeconds)})
+		}
+		app.hold(seconds(*body.Seconds))
+		if *body.Seconds == 0 {
+			return c.JSON(ApiResponse{Data: fiber.Map{}, Message: "hold cleared"})
+		}
+		return c.JSON(ApiResponse{Data: fiber.Map{"seconds": *body.Seconds}, Message: "holding"})
+	})
+
+	server.Get("/api/v1/sprints", func(c *fiber.Ctx) error {
+		return c.JSON(ApiResponse{Data: app.sprints(), Message: "ok"})
 	})
 
-	server.Get("/api/v1/stats", func(c *fiber.Ctx) error {
-		return c.JSON(ApiResponse{Data: app.snapshot(), Message: "ok"})
+	if app.store != nil {
+		server.Get("/api/v1/sessions", func(c *fiber.Ctx) error {
+			records, err := app.store.list()
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(ApiResponse{Data: fiber.Map{}, Message: err.Error()})
+			}
+			return c.JSON(ApiResponse{Data: records, Message: "ok"})
+		})
+
+		server.Get("/api/v1/sessions/:id", func(c *fiber.Ctx) error {
+			id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(ApiResponse{Data: fiber.Map{}, Message: "session id must be an integer"})
+			}
+			record, err := app.store.get(id)
+			if errors.Is(err, errSessionNotFound) {
+				return c.Status(fiber.StatusNotFound).JSON(ApiResponse{Data: fiber.Map{}, Message: err.Error()})
+			}
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(ApiResponse{Data: fiber.Map{}, Message: err.Error()})
+			}
+			return c.JSON(ApiResponse{Data: record, Message: "ok"})
+		})
+
+		server.Get("/api/v1/sessions/:id/export", func(c *fiber.Ctx) error {
+			id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(ApiResponse{Data: fiber.Map{}, Message: "session id must be an integer"})
+			}
+			record, err := app.store.get(id)
+			if errors.Is(err, errSessionNotFound) {
+				return c.Status(fiber.StatusNotFound).JSON(ApiResponse{Data: fiber.Map{}, Message: err.Error()})
+			}
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(ApiResponse{Data: fiber.Map{}, Message: err.Error()})
+			}
+			return sendExport(c, recordExport(record), c.Query("format", "tcx"))
+		})
+
+		server.Delete("/api/v1/sessions/:id", func(c *fiber.Ctx) error {
+			id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(ApiResponse{Data: fiber.Map{}, Message: "session id must be an integer"})
+			}
+			err = app.store.delete(id)
+			if errors.Is(err, errSessionNotFound) {
+				return c.Status(fiber.StatusNotFound).JSON(ApiResponse{Data: fiber.Map{}, Message: err.Error()})
+			}
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(ApiResponse{Data: fiber.Map{}, Message: err.Error()})
+			}
+			return c.JSON(ApiResponse{Data: fiber.Map{}, Message: "session deleted"})
+		})
+	}
+
+	server.Post("/api/v1/session/restore", func(c *fiber.Ctx) error {
+		switch err := app.restoreSession(); {
+		case errors.Is(err, errNothingToRestore):
+			return c.Status(fiber.StatusNotFound).JSON(ApiResponse{Data: fiber.Map{}, Message: err.Error()})
+		case err != nil:
+			return c.Status(fiber.StatusConflict).JSON(ApiResponse{Data: fiber.Map{}, Message: err.Error()})
+		}
+		return c.JSON(ApiResponse{Data: app.snapshot(), Message: "session restored"})
+	})
+
+	server.Post("/api/v1/session/tags", func(c *fiber.Ctx) error {
+		var body struct {
+			Tags []string `json:"tags"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(ApiResponse{Data: fiber.Map{}, Message: "expected {tags: [...]}"})
+		}
+		if err := app.setTags(body.Tags); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(ApiResponse{Data: fiber.Map{}, Message: err.Error()})
+		}
+		return c.JSON(ApiResponse{Data: fiber.Map{"tags": body.Tags}, Message: "tags set"})
+	})
+
+	server.Post("/api/v1/goal/calories", func(c *fiber.Ctx) error {
+		var body struct {
+			TargetKcal float64 `json:"targetKcal"`
+		}
+		if err := c.BodyParser(&body); err != nil || body.TargetKcal < 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(ApiResponse{Data: fiber.Map{}, Message: "expected {targetKcal >= 0}"})
+		}
+		app.setCalorieGoal(body.TargetKcal)
+		if body.TargetKcal == 0 {
+			return c.JSON(ApiResponse{Data: fiber.Map{}, Message: "calorie goal cleared"})
+		}
+		return c.JSON(ApiResponse{Data: fiber.Map{"targetKcal": body.TargetKcal}, Message: "calorie goal set"})
+	})
+
+	server.Post("/api/v1/goal/daily-calories", func(c *fiber.Ctx) error {
+		var body struct {
+			TargetKcal float64 `json:"targetKcal"`
+		}
+		if err := c.BodyParser(&body); err != nil || body.TargetKcal < 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(ApiResponse{Data: fiber.Map{}, Message: "expected {targetKcal >= 0}"})
+		}
+		app.setDailyCalorieGoal(body.TargetKcal)
+		if body.TargetKcal == 0 {
+			return c.JSON(ApiResponse{Data: fiber.Map{}, Message: "daily calorie goal cleared"})
+		}
+		return c.JSON(ApiResponse{Data: fiber.Map{"targetKcal": body.TargetKcal}, Message: "daily calorie goal set"})
 	})
 
 	server.Post("/api/v1/reset", func(c *fiber.Ctx) error {
-		app.reset()
-		return c.JSON(ApiResponse{Data: fiber.Map{}, Message: "reset done"})
+		queries := c.Queries()
+		if len(queries) == 0 {
+			app.reset()
+			return c.JSON(ApiResponse{Data: fiber.Map{}, Message: "reset done"})
+		}
+
+		var fields []string
+		for name, value := range queries {
+			if _, ok := resettableFields[name]; !ok {
+				return c.Status(fiber.StatusBadRequest).JSON(ApiResponse{Data: fiber.Map{}, Message: "unknown reset field " + name})
+			}
+			switch value {
+			case "1":
+				fields = append(fields, name)
+			case "0":
+			default:
+				return c.Status(fiber.StatusBadRequest).JSON(ApiResponse{Data: fiber.Map{}, Message: name + " must be 0 or 1"})
+			}
+		}
+		app.resetFields(fields)
+		return c.JSON(ApiResponse{Data: fiber.Map{"fields": fields}, Message: "reset done"})
+	})
+
+	if config.ProfilesDir != "" {
+		server.Get("/api/v1/profiles", func(c *fiber.Ctx) error {
+			names, err := app.listProfiles()
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(ApiResponse{Data: fiber.Map{}, Message: err.Error()})
+			}
+			return c.JSON(ApiResponse{Data: fiber.Map{"profiles": names, "active": app.activeProfile()}, Message: "ok"})
+		})
+
+		server.Post("/api/v1/profiles/:name/activate", func(c *fiber.Ctx) error {
+			name := c.Params("name")
+			if err := app.activateProfile(name); err != nil {
+				status := fiber.StatusBadRequest
+				if errors.Is(err, os.ErrNotExist) {
+					status = fiber.StatusNotFound
+				}
+				return c.Status(status).JSON(ApiResponse{Data: fiber.Map{}, Message: err.Error()})
+			}
+			log.Printf("profile %s activated", name)
+			return c.JSON(ApiResponse{Data: fiber.Map{"active": name}, Message: "profile activated"})
+		})
+	}
+
+	server.Get("/healthz", func(c *fiber.Ctx) error {
+		health := app.health()
+		if !health.ok() {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(ApiResponse{Data: health, Message: "unhealthy"})
+		}
+		return c.JSON(ApiResponse{Data: health, Message: "ok"})
 	})
 
+	if config.GpsCalibrationSamples > 0 {
+		server.Post("/api/v1/gps-speed", func(c *fiber.Ctx) error {
+			var body struct {
+				Kmh *float64 `json:"kmh"`
+			}
+			if err := c.BodyParser(&body); err != nil || body.Kmh == nil || *body.Kmh < 0 {
+				return c.Status(fiber.StatusBadRequest).JSON(ApiResponse{Data: fiber.Map{}, Message: "expected {kmh >= 0}"})
+			}
+			suggested, err := app.addGpsSpeed(*body.Kmh)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(ApiResponse{Data: fiber.Map{}, Message: err.Error()})
+			}
+			if suggested == 0 {
+				return c.JSON(ApiResponse{Data: fiber.Map{}, Message: "need more samples"})
+			}
+			return c.JSON(ApiResponse{Data: fiber.Map{"suggestedCircumferenceMetres": suggested}, Message: "ok"})
+		})
+	}
+
+	if config.DiagSampleSeconds > 0 {
+		server.Get("/api/v1/diag", func(c *fiber.Ctx) error {
+			return c.JSON(ApiResponse{Data: app.diag(), Message: "ok"})
+		})
+	}
+
+	if config.BatteryI2CBus != "" {
+		server.Get("/api/v1/power", func(c *fiber.Ctx) error {
+			status, ok := app.batteryStatus()
+			if !ok {
+				return c.Status(fiber.StatusServiceUnavailable).JSON(ApiResponse{Data: fiber.Map{}, Message: "no battery reading yet"})
+			}
+			return c.JSON(ApiResponse{Data: status, Message: "ok"})
+		})
+	}
+
+	if config.Debug {
+		server.Post("/api/v1/debug/simulate", func(c *fiber.Ctx) error {
+			var body struct {
+				Rpm     float64 `json:"rpm"`
+				Seconds float64 `json:"seconds"`
+			}
+			if err := c.BodyParser(&body); err != nil || body.Rpm <= 0 || body.Rpm > maxSimulateRpm || body.Seconds <= 0 || body.Seconds > maxSimulateSeconds {
+				return c.Status(fiber.StatusBadRequest).JSON(ApiResponse{Data: fiber.Map{}, Message: fmt.Sprintf("expected {rpm in (0, %d], seconds in (0, %d]}", maxSimulateRpm, maxSimulateSeconds)})
+			}
+			return c.JSON(ApiResponse{Data: app.simulate(body.Rpm, body.Seconds), Message: "ok"})
+		})
+	}
+
 	server.Get("/", func(c *fiber.Ctx) error {
 		c.Set("Content-Type", "text/html; charset=utf-8")
+		c.Vary(fiber.HeaderAcceptEncoding)
+		// with no Accept-Encoding header fiber would pick the first offer
+		if c.Get(fiber.HeaderAcceptEncoding) != "" && c.AcceptsEncodings("gzip") == "gzip" {
+			c.Set(fiber.HeaderContentEncoding, "gzip")
+			return c.Send(indexHTMLGzip)
+		}
 		return c.SendString(indexHTML)
 	})
 
@@ -237,10 +1836,48 @@ func main() {
 		}
 	}()
 
+	go app.runSnapshotTicker()
+	if *simulateFlag {
+		go app.runSimulation(profile)
+	}
+	if app.store != nil {
+		go app.runCheckpoints()
+	}
+	if config.StdoutStatsNdjson {
+		go app.runStdoutStats()
+	}
+	if config.DiagSampleSeconds > 0 {
+		go app.runEdgeRateSampler()
+	}
+	if app.uploader != nil {
+		go app.uploader.run()
+	}
+	if config.DailyResetTime != "" {
+		go app.runDailyReset()
+	}
+	if config.NfcI2CBus != "" {
+		go app.runNFCReader()
+	}
+	if config.BatteryI2CBus != "" {
+		go app.runBatteryMonitor()
+	}
+	if config.StatsTcpPort != "" {
+		listener, err := net.Listen("tcp", ":"+config.StatsTcpPort)
+		if err != nil {
+			log.Fatalf("stats tcp: %v", err)
+		}
+		defer listener.Close()
+		go app.serveStatsTCP(listener)
+	}
+
 	log.Println("vital is running! 🚴")
 
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
 	<-signals
+	app.streams.stop()
 	_ = server.Shutdown()
+	// the ride ends with the service; the checkpoint only covers crashes
+	app.reset()
+	app.waitForUploads(shutdownUploadTimeout)
 }
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestCountPulseConcurrent counts pulses from one goroutine, as the line's
+// event handler does, while others take snapshots and reset the session.
+// Run with -race. Every pulse must end up in exactly one session.
+func TestCountPulseConcurrent(t *testing.T) {
+	app := NewApp(defaultConfig())
+	app.simulated = true
+
+	const total = 20000
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(done)
+		ts := time.Duration(0)
+		for i := 0; i < total; i++ {
+			ts += 50 * time.Millisecond
+			app.countPulse(ts, debounceInterval)
+		}
+	}()
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				app.snapshot()
+				app.accrue()
+			}
+		}()
+	}
+
+	var finished uint64
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(time.Millisecond):
+			}
+			app.reset()
+			app.lock()
+			if app.previous != nil {
+				finished += app.previous.Pulses
+				app.previous = nil
+			}
+			app.unlock()
+		}
+	}()
+
+	wg.Wait()
+	current := app.snapshot().TotalRevolutions
+	if got := finished + current; got != total {
+		t.Fatalf("counted %d pulses (%d finished, %d current), want %d", got, finished, current, total)
+	}
+}
+
+// TestSplitAfterPulseDistanceShrinks switches to a smaller circumference
+// mid-ride, which needs more pulses per split, and checks that the best split
+// still spans the whole split distance.
+func TestSplitAfterPulseDistanceShrinks(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.CircumferenceInMetres = 1
+	cfg.SplitDistanceMetres = 3
+	app := NewApp(cfg)
+
+	ts := time.Duration(0)
+	pulse := func(n int, every time.Duration) {
+		for i := 0; i < n; i++ {
+			ts += every
+			app.countPulse(ts, debounceInterval)
+		}
+	}
+	// 3 m at 1 m per pulse: three 1.5 s intervals, wrapping the ring
+	pulse(10, 1500*time.Millisecond)
+	app.applyBikeTag(bikeTag{BikeID: "small", CircumferenceInMetres: 0.75})
+	// 3 m at 0.75 m per pulse: four 1 s intervals
+	pulse(10, time.Second)
+
+	if got := app.snapshot().BestKilometreSeconds; got != 4 {
+		t.Fatalf("BestKilometreSeconds = %v, want 4", got)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		ok     bool
+	}{
+		{"defaults", func(c *Config) {}, true},
+		{"empty chip", func(c *Config) { c.ChipName = "" }, false},
+		{"empty port", func(c *Config) { c.HttpPort = "" }, false},
+		{"negative circumference", func(c *Config) { c.CircumferenceInMetres = -1 }, false},
+		{"zero circumference", func(c *Config) { c.CircumferenceInMetres = 0 }, false},
+		{"zero body weight", func(c *Config) { c.BodyWeightKilograms = 0 }, false},
+		{"zero idle timeout", func(c *Config) { c.IdleTimeoutSeconds = 0 }, false},
+		{"negative idle timeout", func(c *Config) { c.IdleTimeoutSeconds = -2 }, false},
+		{"zero snapshot tick", func(c *Config) { c.SnapshotTickSeconds = 0 }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaultConfig()
+			tt.modify(&cfg)
+			err := cfg.validate()
+			if tt.ok && err != nil {
+				t.Fatalf("validate() = %v, want nil", err)
+			}
+			if !tt.ok && err == nil {
+				t.Fatal("validate() = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestLoadConfigEnvIsValidated(t *testing.T) {
+	for env, value := range map[string]string{
+		"VITAL_CIRCUMFERENCE_IN_METRES": "-1",
+		"VITAL_IDLE_TIMEOUT_SECONDS":    "0",
+		"VITAL_HTTP_PORT":               "",
+	} {
+		t.Run(env, func(t *testing.T) {
+			t.Setenv(env, value)
+			cfg, err := loadConfig("")
+			if err != nil {
+				t.Fatalf("loadConfig: %v", err)
+			}
+			if err := cfg.validate(); err == nil {
+				t.Fatalf("%s=%q passed validation", env, value)
+			}
+		})
+	}
+}
+
+func TestSimulateIntervalClearsDebounce(t *testing.T) {
+	if got := simulateInterval(maxSimulateRpm); got <= debounceInterval {
+		t.Fatalf("simulateInterval(%d) = %v, not above the %v debounce", maxSimulateRpm, got, debounceInterval)
+	}
+}
+
+func TestParseSimulateProfile(t *testing.T) {
+	if _, err := parseSimulateProfile(defaultSimulateProfile); err != nil {
+		t.Fatalf("default profile: %v", err)
+	}
+	for _, profile := range []string{"", "90", "90:0", "-5:10", "1e9:10", "90:10,abc:5"} {
+		if _, err := parseSimulateProfile(profile); err == nil {
+			t.Errorf("parseSimulateProfile(%q) = nil error", profile)
+		}
+	}
+}
+
+// TestStrokeModeReportsZeroStrokes checks a rower that has not started yet
+// still reports its stroke count, and a bike never does.
+func TestStrokeModeReportsZeroStrokes(t *testing.T) {
+	for mode, want := range map[string]bool{PulseModeStroke: true, PulseModeWheel: false} {
+		t.Run(mode, func(t *testing.T) {
+			cfg := defaultConfig()
+			cfg.PulseMode = mode
+			data, err := json.Marshal(NewApp(cfg).snapshot())
+			if err != nil {
+				t.Fatal(err)
+			}
+			var fields map[string]any
+			if err := json.Unmarshal(data, &fields); err != nil {
+				t.Fatal(err)
+			}
+			for _, key := range []string{"totalStrokes", "strokeRate"} {
+				value, ok := fields[key]
+				if ok != want {
+					t.Errorf("%s present = %v, want %v", key, ok, want)
+				}
+				if ok && value != 0.0 {
+					t.Errorf("%s = %v, want 0", key, value)
+				}
+			}
+		})
+	}
+}
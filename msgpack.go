@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const mimeMsgpack = "application/msgpack"
+
+// encodeMsgpack encodes v as MessagePack using the json field names, so
+// both encodings carry the same keys.
+func encodeMsgpack(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// sendNegotiated replies with MessagePack when the client asks for it and
+// JSON otherwise.
+func sendNegotiated(c *fiber.Ctx, response ApiResponse) error {
+	if c.Accepts(fiber.MIMEApplicationJSON, mimeMsgpack) != mimeMsgpack {
+		return c.JSON(response)
+	}
+	body, err := encodeMsgpack(response)
+	if err != nil {
+		return err
+	}
+	c.Set(fiber.HeaderContentType, mimeMsgpack)
+	return c.Send(body)
+}
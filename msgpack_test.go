@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func decodeMsgpack(t *testing.T, data []byte, v any) {
+	t.Helper()
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	if err := dec.Decode(v); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+}
+
+func TestMsgpackStatsRoundTrip(t *testing.T) {
+	delta := -12.5
+	tests := []struct {
+		name    string
+		stats   Stats
+		present []string
+		absent  []string
+	}{
+		{
+			name: "with target",
+			stats: Stats{
+				SpeedKilometresPerHour: 24.31,
+				TotalRevolutions:       1200,
+				DistanceKilometres:     2.534,
+				StartTimeEpochSeconds:  1700000000,
+				StartTimeIso8601:       "2023-11-14T22:13:20Z",
+				MovingMinutes:          6.5,
+				KiloCalories:           48.2,
+				BikeId:                 "studio-3",
+				TargetSpeedKmh:         28,
+				TargetDeltaPercent:     &delta,
+				Tags:                   []string{"tempo"},
+				CalorieEstimates:       map[string]float64{"met": 48.2, "power": 51},
+			},
+			present: []string{"speedKilometresPerHour", "totalRevolutions", "bikeId", "targetSpeedKmh", "targetDeltaPercent", "tags", "calorieEstimates"},
+			absent:  []string{"SpeedKilometresPerHour", "TargetDeltaPercent", "totalStrokes", "onHold"},
+		},
+		{
+			name: "empty optionals",
+			stats: Stats{
+				TotalRevolutions: 3,
+				StopCount:        0,
+				StartTimeIso8601: "2023-11-14T22:13:20Z",
+			},
+			// zero but not omitempty
+			present: []string{"speedKilometresPerHour", "stopCount", "kiloCalories"},
+			absent:  []string{"targetDeltaPercent", "targetSpeedKmh", "bikeId", "tags", "calorieEstimates"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := encodeMsgpack(tt.stats)
+			if err != nil {
+				t.Fatalf("encode: %v", err)
+			}
+
+			var fields map[string]any
+			decodeMsgpack(t, data, &fields)
+			for _, key := range tt.present {
+				if _, ok := fields[key]; !ok {
+					t.Errorf("key %q missing", key)
+				}
+			}
+			for _, key := range tt.absent {
+				if _, ok := fields[key]; ok {
+					t.Errorf("key %q should be omitted", key)
+				}
+			}
+
+			var got Stats
+			decodeMsgpack(t, data, &got)
+			if !reflect.DeepEqual(got, tt.stats) {
+				t.Errorf("round trip:\n got %+v\nwant %+v", got, tt.stats)
+			}
+		})
+	}
+}
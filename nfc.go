@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	pn532CmdSAMConfiguration    = 0x14
+	pn532CmdInListPassiveTarget = 0x4A
+	pn532CmdInDataExchange      = 0x40
+	ntagCmdRead                 = 0x30
+	ntagFirstUserPage           = 4
+	ntagMaxPages                = 32
+	pn532DefaultI2CAddress      = 0x24
+	pn532CommandTimeout         = time.Second
+	pn532ListTargetTimeout      = 300 * time.Millisecond
+	minTagCircumferenceInMetres = 0.5
+	maxTagCircumferenceInMetres = 4.0
+)
+
+var (
+	pn532Ack = []byte{0x00, 0x00, 0xFF, 0x00, 0xFF, 0x00}
+
+	errNoTag          = errors.New("no tag present")
+	errPN532Timeout   = errors.New("pn532: timed out waiting for reader")
+	errNDEFIncomplete = errors.New("ndef: message continues past data read so far")
+)
+
+// pn532 talks to an NXP PN532 NFC controller over I2C.
+type pn532 struct {
+	dev *i2cDevice
+}
+
+func pn532Frame(data []byte) []byte {
+	length := byte(len(data))
+	frame := []byte{0x00, 0x00, 0xFF, length, ^length + 1}
+	var sum byte
+	for _, b := range data {
+		sum += b
+	}
+	frame = append(frame, data...)
+	return append(frame, ^sum+1, 0x00)
+}
+
+// waitReady polls the status byte the PN532 prepends to every I2C read.
+func (p *pn532) waitReady(timeout time.Duration) error {
+	status := make([]byte, 1)
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if _, err := p.dev.Read(status); err != nil {
+			return err
+		}
+		if status[0]&0x01 == 0x01 {
+			return nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return errPN532Timeout
+}
+
+// command sends cmd with params and returns the response payload (after the
+// response code).
+func (p *pn532) command(cmd byte, params []byte, timeout time.Duration) ([]byte, error) {
+	if _, err := p.dev.Write(pn532Frame(append([]byte{0xD4, cmd}, params...))); err != nil {
+		return nil, err
+	}
+	if err := p.waitReady(pn532CommandTimeout); err != nil {
+		return nil, err
+	}
+	ack := make([]byte, 1+len(pn532Ack))
+	if _, err := p.dev.Read(ack); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(ack[1:], pn532Ack) {
+		return nil, fmt.Errorf("pn532: command 0x%02x not acknowledged", cmd)
+	}
+
+	if err := p.waitReady(timeout); err != nil {
+		// an ACK from the host aborts the pending command
+		_, _ = p.dev.Write(pn532Ack)
+		return nil, err
+	}
+	buf := make([]byte, 64)
+	if _, err := p.dev.Read(buf); err != nil {
+		return nil, err
+	}
+	frame := buf[1:]
+	if !bytes.HasPrefix(frame, []byte{0x00, 0x00, 0xFF}) || frame[3]+frame[4] != 0 {
+		return nil, errors.New("pn532: malformed response frame")
+	}
+	length := int(frame[3])
+	if length < 2 || 5+length > len(frame) {
+		return nil, errors.New("pn532: bad response length")
+	}
+	data := frame[5 : 5+length]
+	if data[0] != 0xD5 || data[1] != cmd+1 {
+		return nil, fmt.Errorf("pn532: unexpected response to command 0x%02x", cmd)
+	}
+	return data[2:], nil
+}
+
+func (p *pn532) init() error {
+	// normal mode, no virtual card timeout, use IRQ
+	_, err := p.command(pn532CmdSAMConfiguration, []byte{0x01, 0x14, 0x01}, pn532CommandTimeout)
+	return err
+}
+
+// readTag returns the UID and the NDEF text of an NTAG/Ultralight tag in
+// the field, or errNoTag.
+func (p *pn532) readTag() ([]byte, string, error) {
+	res, err := p.command(pn532CmdInListPassiveTarget, []byte{0x01, 0x00}, pn532ListTargetTimeout)
+	if errors.Is(err, errPN532Timeout) {
+		return nil, "", errNoTag
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	// NbTg, Tg, SENS_RES (2), SEL_RES, NFCIDLength, NFCID...
+	if len(res) < 6 || res[0] == 0 {
+		return nil, "", errNoTag
+	}
+	uidLength := int(res[5])
+	if len(res) < 6+uidLength {
+		return nil, "", errors.New("pn532: truncated target data")
+	}
+	uid := append([]byte(nil), res[6:6+uidLength]...)
+
+	var memory []byte
+	for page := ntagFirstUserPage; page < ntagFirstUserPage+ntagMaxPages; page += 4 {
+		res, err := p.command(pn532CmdInDataExchange, []byte{0x01, ntagCmdRead, byte(page)}, pn532CommandTimeout)
+		if err != nil {
+			return nil, "", err
+		}
+		if len(res) < 17 || res[0] != 0x00 {
+			return nil, "", fmt.Errorf("pn532: reading page %d failed", page)
+		}
+		memory = append(memory, res[1:17]...)
+
+		text, err := parseNDEFText(memory)
+		if errors.Is(err, errNDEFIncomplete) {
+			continue
+		}
+		return uid, text, err
+	}
+	return nil, "", errNDEFIncomplete
+}
+
+// parseNDEFText returns the first well-known text record from the NDEF
+// message TLV in tag memory.
+func parseNDEFText(memory []byte) (string, error) {
+	for i := 0; i < len(memory); {
+		switch memory[i] {
+		case 0x00: // NULL TLV
+			i++
+			continue
+		case 0xFE: // terminator
+			return "", errors.New("ndef: no text record on tag")
+		}
+		if i+1 >= len(memory) {
+			return "", errNDEFIncomplete
+		}
+		tlvType, length, offset := memory[i], int(memory[i+1]), i+2
+		if length == 0xFF {
+			if i+3 >= len(memory) {
+				return "", errNDEFIncomplete
+			}
+			length, offset = int(memory[i+2])<<8|int(memory[i+3]), i+4
+		}
+		if offset+length > len(memory) {
+			return "", errNDEFIncomplete
+		}
+		if tlvType == 0x03 {
+			return parseNDEFTextRecord(memory[offset : offset+length])
+		}
+		i = offset + length
+	}
+	return "", errNDEFIncomplete
+}
+
+func parseNDEFTextRecord(record []byte) (string, error) {
+	for len(record) >= 3 {
+		header, typeLength := record[0], int(record[1])
+		offset, payloadLength := 2, 0
+		if header&0x10 != 0 { // short record
+			payloadLength, offset = int(record[2]), 3
+		} else {
+			if len(record) < 6 {
+				break
+			}
+			payloadLength = int(record[2])<<24 | int(record[3])<<16 | int(record[4])<<8 | int(record[5])
+			offset = 6
+		}
+		idLength := 0
+		if header&0x08 != 0 {
+			if offset >= len(record) {
+				break
+			}
+			idLength = int(record[offset])
+			offset++
+		}
+		end := offset + typeLength + idLength + payloadLength
+		if end > len(record) {
+			break
+		}
+		recordType := record[offset : offset+typeLength]
+		payload := record[offset+typeLength+idLength : end]
+		if header&0x07 == 0x01 && string(recordType) == "T" && len(payload) > 0 {
+			languageLength := int(payload[0] & 0x3F)
+			if 1+languageLength <= len(payload) {
+				return string(payload[1+languageLength:]), nil
+			}
+		}
+		if header&0x40 != 0 { // message end
+			break
+		}
+		record = record[end:]
+	}
+	return "", errors.New("ndef: no text record on tag")
+}
+
+type bikeTag struct {
+	BikeID                string
+	CircumferenceInMetres float64
+}
+
+// parseBikeTag reads "bike=<id>;circumference=<metres>" (';' or newline
+// separated) from a tag's text record.
+func parseBikeTag(text string) (bikeTag, error) {
+	var tag bikeTag
+	fields := strings.FieldsFunc(text, func(r rune) bool { return r == ';' || r == '\n' })
+	for _, field := range fields {
+		key, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			continue
+		}
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "bike":
+			tag.BikeID = strings.TrimSpace(value)
+		case "circumference":
+			c, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil {
+				return bikeTag{}, fmt.Errorf("tag circumference: %w", err)
+			}
+			tag.CircumferenceInMetres = c
+		}
+	}
+	if tag.CircumferenceInMetres < minTagCircumferenceInMetres || tag.CircumferenceInMetres > maxTagCircumferenceInMetres {
+		return bikeTag{}, fmt.Errorf("tag circumference %.3f m out of range", tag.CircumferenceInMetres)
+	}
+	return tag, nil
+}
+
+func (app *App) applyBikeTag(tag bikeTag) {
+	app.lock()
+	defer app.unlock()
+	app.bankDistance()
+	app.Session.BikeID = tag.BikeID
+	app.Session.CircumferenceInMetres = tag.CircumferenceInMetres
+}
+
+// runNFCReader polls the PN532 for bike tags and loads each newly presented
+// tag into the session. Any failure is logged and the reader keeps polling;
+// if the reader cannot be set up at all it is disabled.
+func (app *App) runNFCReader() {
+	cfg := app.currentConfig()
+	dev, err := openI2C(cfg.NfcI2CBus, cfg.NfcI2CAddress)
+	if err != nil {
+		log.Printf("nfc: %v (reader disabled)", err)
+		return
+	}
+	defer dev.Close()
+
+	reader := &pn532{dev: dev}
+	if err := reader.init(); err != nil {
+		log.Printf("nfc: %v (reader disabled)", err)
+		return
+	}
+
+	var lastUID []byte
+	lastErr := ""
+	for {
+		time.Sleep(seconds(app.currentConfig().NfcPollSeconds))
+
+		uid, text, err := reader.readTag()
+		if errors.Is(err, errNoTag) {
+			lastUID = nil
+			continue
+		}
+		if err != nil {
+			if err.Error() != lastErr {
+				log.Printf("nfc: %v", err)
+				lastErr = err.Error()
+			}
+			continue
+		}
+		lastErr = ""
+		if bytes.Equal(uid, lastUID) {
+			continue
+		}
+		lastUID = uid
+
+		tag, err := parseBikeTag(text)
+		if err != nil {
+			log.Printf("nfc: %v", err)
+			continue
+		}
+		app.applyBikeTag(tag)
+		log.Printf("nfc: loaded bike %q, circumference %.3f m", tag.BikeID, tag.CircumferenceInMetres)
+	}
+}
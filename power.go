@@ -0,0 +1,28 @@
+package main
+
+const (
+	KcalModelMet   = "met"
+	KcalModelPower = "power"
+
+	gravity    = 9.81
+	airDensity = 1.225
+	// share of metabolic energy that reaches the pedals
+	grossEfficiency = 0.24
+)
+
+// estimateWatts is the power needed to hold speedKmh on flat ground
+// against rolling resistance and air drag. It is a road model, so on a
+// trainer DragAreaSquareMetres stands in for the unit's resistance. It uses
+// speed only: cadence changes the gearing the power goes through, not how
+// much it takes to hold a speed.
+func (c Config) estimateWatts(speedKmh float64) float64 {
+	v := speedKmh / 3.6
+	mass := c.BodyWeightKilograms + c.BikeWeightKilograms
+	return v * (c.RollingResistanceCoeff*mass*gravity + 0.5*airDensity*c.DragAreaSquareMetres*v*v)
+}
+
+// kcalPerMinuteAtWatts converts mechanical power into metabolic burn,
+// including the configured correction factor.
+func (c Config) kcalPerMinuteAtWatts(watts float64) float64 {
+	return watts * 60.0 / 4184.0 / grossEfficiency * c.CalorieCorrectionFactor
+}
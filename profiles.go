@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var profileNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// A profile is a JSON file <ProfilesDir>/<name>.json holding any subset of
+// Config fields, e.g. {"CircumferenceInMetres": 2.105,
+// "BodyWeightKilograms": 82}. Activating it overlays those fields on the
+// live config. Settings only read at startup (ports, background workers)
+// keep their startup values.
+func (a *App) listProfiles() ([]string, error) {
+	entries, err := os.ReadDir(a.currentConfig().ProfilesDir)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		if ok && !entry.IsDir() && profileNamePattern.MatchString(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// currentConfig returns the live config without taking the lock. A profile
+// switch publishes a new one rather than changing it, so callers must treat
+// it as read-only.
+func (a *App) currentConfig() *Config {
+	return a.live.Load()
+}
+
+// setConfig replaces the live config, which may change the pulse distance
+// and the timezone. Caller must hold the lock.
+func (a *App) setConfig(cfg Config) {
+	a.bankDistance()
+	if cfg.Timezone != a.Config.Timezone {
+		// validate() has already loaded it once
+		if loc, err := cfg.location(); err == nil {
+			a.location = loc
+		}
+	}
+	a.Config = cfg
+	a.live.Store(&cfg)
+}
+
+func (a *App) activeProfile() string {
+	a.lock()
+	defer a.unlock()
+	return a.ActiveProfile
+}
+
+// activateProfile swaps in the named profile under the lock, reopening the
+// GPIO line only when the line settings changed. Switches are serialised so
+// two of them never reopen the lines at once.
+func (a *App) activateProfile(name string) error {
+	if !profileNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid profile name %q", name)
+	}
+	a.profileGuard <- struct{}{}
+	defer func() { <-a.profileGuard }()
+
+	data, err := os.ReadFile(filepath.Join(a.currentConfig().ProfilesDir, name+".json"))
+	if err != nil {
+		return err
+	}
+
+	cfg := *a.currentConfig()
+	// the copy still shares its map with the live config, which the header
+	// middleware may be ranging over
+	cfg.ResponseHeaders = maps.Clone(cfg.ResponseHeaders)
+	// as in loadConfig, a misspelt key is an error rather than ignored
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&cfg); err != nil {
+		return fmt.Errorf("profile %s: %w", name, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return fmt.Errorf("profile %s: %w", name, err)
+	}
+
+	a.lock()
+	previous, previousName := a.Config, a.ActiveProfile
+	a.setConfig(cfg)
+	a.ActiveProfile = name
+	a.unlock()
+
+	if !sameLineSettings(previous, cfg) {
+		// the edge handler takes the lock, so the line is swapped outside it
+		a.closeGPIO()
+		if err := a.openGPIO(); err != nil {
+			a.lock()
+			a.setConfig(previous)
+			a.ActiveProfile = previousName
+			a.unlock()
+			_ = a.openGPIO()
+			return fmt.Errorf("profile %s: gpio: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func sameLineSettings(x, y Config) bool {
+	return x.chipFor(x.LineChipName) == y.chipFor(y.LineChipName) && x.LineOffset == y.LineOffset &&
+		x.QuadratureLineOffsets == y.QuadratureLineOffsets &&
+		x.CadenceChipName == y.CadenceChipName && x.CadenceLineOffset == y.CadenceLineOffset
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeProfile(t *testing.T, dir, name, body string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name+".json"), []byte(body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestActivateProfileRejectsUnknownKeys(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.ProfilesDir = t.TempDir()
+	writeProfile(t, cfg.ProfilesDir, "typo", `{"CircumferenceInMeters": 2.1}`)
+	app := NewApp(cfg)
+	app.simulated = true
+
+	if err := app.activateProfile("typo"); err == nil || !strings.Contains(err.Error(), "CircumferenceInMeters") {
+		t.Fatalf("activateProfile = %v, want an unknown field error", err)
+	}
+	if got := app.currentConfig().CircumferenceInMetres; got != cfg.CircumferenceInMetres {
+		t.Fatalf("CircumferenceInMetres = %v after a rejected profile", got)
+	}
+}
+
+func TestActivateProfileSwitchesTimezone(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Timezone = "UTC"
+	cfg.ProfilesDir = t.TempDir()
+	writeProfile(t, cfg.ProfilesDir, "tokyo", `{"Timezone": "Asia/Tokyo"}`)
+	app := NewApp(cfg)
+	app.simulated = true
+
+	if err := app.activateProfile("tokyo"); err != nil {
+		t.Fatal(err)
+	}
+	if got := app.snapshot().StartTimeIso8601; !strings.HasSuffix(got, "+09:00") {
+		t.Fatalf("StartTimeIso8601 = %q, want a +09:00 offset", got)
+	}
+	if got := app.currentLocation().String(); got != "Asia/Tokyo" {
+		t.Fatalf("location = %q, want Asia/Tokyo", got)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import "github.com/warthog618/go-gpiocdev"
+
+// quadratureSteps maps (previous AB << 2 | current AB) to the position
+// change: +1 when B leads A, -1 when A leads B, 0 for no change or an
+// impossible jump across both channels.
+var quadratureSteps = [16]int8{
+	0, 1, -1, 0,
+	-1, 0, 0, 1,
+	1, 0, 0, -1,
+	0, -1, 1, 0,
+}
+
+// quadrature decodes a two-channel encoder into a signed position. Only
+// forward motion past the furthest point reached counts as pulses; going
+// backwards shows up in the position and direction reported by diag. The
+// guard keeps the state machine consistent however the event handlers are
+// scheduled.
+type quadrature struct {
+	guard    chan struct{}
+	offsetA  int
+	state    uint8
+	position int64
+	// +1 or -1 for the latest step, 0 before the first
+	direction int8
+	// furthest full cycle reached, so backing up and coming forward again
+	// does not count the same ground twice
+	high int64
+}
+
+func newQuadrature(offsetA, levelA, levelB int) *quadrature {
+	return &quadrature{
+		guard:   make(chan struct{}, 1),
+		offsetA: offsetA,
+		state:   uint8(levelA&1)<<1 | uint8(levelB&1),
+	}
+}
+
+// step applies one edge and reports whether it completed a new forward
+// cycle.
+func (q *quadrature) step(event gpiocdev.LineEvent) bool {
+	q.guard <- struct{}{}
+	defer func() { <-q.guard }()
+
+	bit := uint8(1)
+	if event.Offset == q.offsetA {
+		bit = 2
+	}
+	next := q.state &^ bit
+	if event.Type == gpiocdev.LineEventRisingEdge {
+		next |= bit
+	}
+	step := quadratureSteps[q.state<<2|next]
+	q.position += int64(step)
+	q.state = next
+	if step != 0 {
+		q.direction = step
+	}
+
+	// four transitions make one full cycle
+	if cycle := q.position / 4; cycle > q.high {
+		q.high = cycle
+		return true
+	}
+	return false
+}
+
+// reading returns the position in full cycles and the latest direction.
+func (q *quadrature) reading() (int64, int8) {
+	q.guard <- struct{}{}
+	defer func() { <-q.guard }()
+	return q.position / 4, q.direction
+}
+
+// openQuadrature requests both encoder channels and seeds the decoder with
+// their current levels.
+func (a *App) openQuadrature() error {
+	cfg := a.currentConfig()
+	offsets := cfg.QuadratureLineOffsets
+	lines, err := gpiocdev.RequestLines(cfg.chipFor(cfg.LineChipName), offsets[:],
+		gpiocdev.AsInput,
+		gpiocdev.WithPullUp,
+		gpiocdev.WithBothEdges,
+		gpiocdev.WithEventHandler(a.onQuadratureEdge),
+		gpiocdev.WithMonotonicEventClock,
+	)
+	if err != nil {
+		return err
+	}
+	levels := make([]int, 2)
+	if err := lines.Values(levels); err != nil {
+		_ = lines.Close()
+		return err
+	}
+	a.quadrature.Store(newQuadrature(offsets[0], levels[0], levels[1]))
+	a.lock()
+	a.Lines = lines
+	a.unlock()
+	return nil
+}
+
+func (app *App) onQuadratureEdge(event gpiocdev.LineEvent) {
+	if event.Type == gpiocdev.LineEventRisingEdge {
+		app.edges.rising.Add(1)
+	} else {
+		app.edges.falling.Add(1)
+	}
+	q := app.quadrature.Load()
+	if q == nil {
+		return
+	}
+	if q.step(event) {
+		// the decoder already rejects bounce, so no debounce window here
+		app.countPulse(event.Timestamp, 0)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+// finishedSession is the last session reset() set aside, kept so an
+// accidental reset can be undone.
+type finishedSession struct {
+	Session        Session
+	Pulses         uint64
+	DistanceMetres float64
+	EndedAt        time.Time
+}
+
+var (
+	errNothingToRestore = errors.New("no finished session to restore")
+	errCurrentIsLarger  = errors.New("current session has more riding than the finished one")
+)
+
+// restoreSession swaps the last finished session back in place of the
+// current one. Unless RestoreAlways is set, it refuses when the current
+// session has already covered more ground than the one it would replace.
+// Any upload of the finished session has already gone out and is not
+// recalled, but its stored record is dropped: the session is running again,
+// and checkpoints skip sessions that have a record.
+func (a *App) restoreSession() error {
+	id, err := a.reviveSession()
+	if err != nil {
+		return err
+	}
+	if a.store != nil {
+		if err := a.store.delete(id); err != nil && !errors.Is(err, errSessionNotFound) {
+			log.Printf("sessions: restore: %v", err)
+		}
+	}
+	return nil
+}
+
+// reviveSession makes the last finished session current and returns its ID.
+func (a *App) reviveSession() (int64, error) {
+	a.lock()
+	defer a.unlock()
+
+	previous := a.previous
+	if previous == nil {
+		return 0, errNothingToRestore
+	}
+	if !a.Config.RestoreAlways && a.pulses.Load() > previous.Pulses {
+		return 0, errCurrentIsLarger
+	}
+
+	if !a.Daily.Since.After(previous.EndedAt) {
+		// still counted in today's totals; reset() will add it again
+		a.Daily.Sessions--
+		a.Daily.DistanceMetres -= previous.DistanceMetres
+		a.Daily.MovingSeconds -= previous.Session.MovingSeconds
+		a.Daily.KiloCalories -= previous.Session.KiloCalories
+	}
+
+	a.resumeSession(previous.Session, previous.Pulses)
+	a.previous = nil
+	return previous.Session.ID, nil
+}
+
+// resumeSession makes session current again. The time it spent set aside
+// is not riding, and the next pulse starts timing afresh: the split ring,
+// speed window and acceleration hold event timestamps and intervals that
+// may come from before a reboot, when the monotonic clock was different, so
+// they are cleared. Caller must hold the lock.
+func (a *App) resumeSession(session Session, pulses uint64) {
+	a.Session = session
+	if a.Session.ID == 0 {
+		// checkpointed before sessions had IDs
+		a.Session.ID = a.Session.StartTimeEpochSeconds
+	}
+	a.lastSessionID = max(a.lastSessionID, a.Session.ID)
+	a.Session.LastCalcWall = time.Now()
+	a.Session.WasMoving = false
+	a.Session.SplitPulses, a.Session.SplitNext = nil, 0
+	a.Session.Intervals, a.Session.IntervalNext = nil, 0
+	a.Session.LastInterval, a.Session.Acceleration = 0, 0
+	a.Session.Decelerating, a.Session.StoppedEarly = false, false
+	a.Session.CadenceInterval = 0
+	a.pulses.Store(pulses)
+	a.lastEdge.Store(0)
+	a.cadenceLastEdge.Store(0)
+	a.holdUntil.Store(0)
+}
@@ -0,0 +1,28 @@
+package main
+
+const (
+	RideTypeCommute   = "commute"
+	RideTypeInterval  = "interval"
+	RideTypeEndurance = "endurance"
+	RideTypeRecovery  = "recovery"
+)
+
+// classifyRide tags a finished ride from what the session recorded: many
+// sprints read as intervals, frequent stops as a commute, and a low moving
+// average as recovery; anything else is endurance. A zero threshold
+// disables its rule. Caller must hold the lock.
+func (app *App) classifyRide(final Stats) string {
+	c := app.Config
+	if c.RideTypeIntervalSprints > 0 && len(app.Session.Sprints) >= c.RideTypeIntervalSprints {
+		return RideTypeInterval
+	}
+	if c.RideTypeCommuteStopsPerKm > 0 && final.DistanceKilometres > 0 &&
+		float64(final.StopCount)/final.DistanceKilometres >= c.RideTypeCommuteStopsPerKm {
+		return RideTypeCommute
+	}
+	if c.RideTypeRecoveryKmh > 0 && final.MovingMinutes > 0 &&
+		final.DistanceKilometres/(final.MovingMinutes/60.0) < c.RideTypeRecoveryKmh {
+		return RideTypeRecovery
+	}
+	return RideTypeEndurance
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/warthog618/go-gpiocdev"
+)
+
+// defaultSimulateProfile is a steady ride with a surge, an easy spell and
+// a stop, over and over.
+const defaultSimulateProfile = "90:60,120:30,60:30,0:15"
+
+type simSegment struct {
+	Rpm     float64
+	Seconds float64
+}
+
+// parseSimulateProfile reads comma-separated rpm:seconds segments; an rpm
+// of zero is a rest, and anything above maxSimulateRpm is rejected as its
+// pulses would fall inside the debounce window.
+func parseSimulateProfile(profile string) ([]simSegment, error) {
+	var segments []simSegment
+	for _, part := range strings.Split(profile, ",") {
+		rpmText, secondsText, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok {
+			return nil, fmt.Errorf("simulate profile: %q is not rpm:seconds", part)
+		}
+		rpm, err := strconv.ParseFloat(rpmText, 64)
+		if err != nil || rpm < 0 || rpm > maxSimulateRpm {
+			return nil, fmt.Errorf("simulate profile: rpm %q must be between 0 and %d", rpmText, maxSimulateRpm)
+		}
+		secs, err := strconv.ParseFloat(secondsText, 64)
+		if err != nil || secs <= 0 {
+			return nil, fmt.Errorf("simulate profile: bad seconds %q", secondsText)
+		}
+		segments = append(segments, simSegment{Rpm: rpm, Seconds: secs})
+	}
+	return segments, nil
+}
+
+// runSimulation stands in for the GPIO line, feeding synthetic falling
+// edges through onEdge according to the profile, repeating it forever.
+func (app *App) runSimulation(profile []simSegment) {
+	log.Printf("simulate: no GPIO, generating pulses from %d segment profile", len(profile))
+	for {
+		for _, segment := range profile {
+			end := time.Now().Add(seconds(segment.Seconds))
+			if segment.Rpm == 0 {
+				time.Sleep(time.Until(end))
+				continue
+			}
+			pulses := time.NewTicker(simulateInterval(segment.Rpm))
+			for now := range pulses.C {
+				if now.After(end) {
+					break
+				}
+				app.onEdge(gpiocdev.LineEvent{Type: gpiocdev.LineEventFallingEdge, Timestamp: monotonicNow()})
+			}
+			pulses.Stop()
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import "time"
+
+type Sprint struct {
+	StartTimeEpochSeconds      int64   `json:"startTimeEpochSeconds"`
+	DurationSeconds            float64 `json:"durationSeconds"`
+	PeakSpeedKilometresPerHour float64 `json:"peakSpeedKilometresPerHour"`
+}
+
+// trackSprint feeds one instantaneous speed into the sprint detector. A
+// sprint starts above SprintEntryKmh and only ends once speed drops below
+// the lower SprintExitKmh; efforts shorter than SprintMinSeconds are
+// discarded. Caller must hold the lock.
+func (app *App) trackSprint(now time.Time, speedKmh float64) {
+	if app.Config.SprintEntryKmh <= 0 {
+		return
+	}
+	s := &app.Session
+	if s.SprintStart.IsZero() {
+		if speedKmh >= app.Config.SprintEntryKmh {
+			s.SprintStart, s.SprintLast, s.SprintPeak = now, now, speedKmh
+		}
+		return
+	}
+	if speedKmh < app.Config.SprintExitKmh {
+		app.endSprint()
+		return
+	}
+	s.SprintLast = now
+	s.SprintPeak = max(s.SprintPeak, speedKmh)
+}
+
+// endSprint closes the sprint in progress, if any. Caller must hold the
+// lock.
+func (app *App) endSprint() {
+	s := &app.Session
+	if s.SprintStart.IsZero() {
+		return
+	}
+	duration := s.SprintLast.Sub(s.SprintStart).Seconds()
+	if duration >= app.Config.SprintMinSeconds {
+		s.Sprints = append(s.Sprints, Sprint{
+			StartTimeEpochSeconds:      s.SprintStart.Unix(),
+			DurationSeconds:            round(duration, 1),
+			PeakSpeedKilometresPerHour: round(s.SprintPeak, 2),
+		})
+	}
+	s.SprintStart, s.SprintLast, s.SprintPeak = time.Time{}, time.Time{}, 0
+}
+
+func (app *App) sprints() []Sprint {
+	app.lock()
+	defer app.unlock()
+	return append([]Sprint{}, app.Session.Sprints...)
+}
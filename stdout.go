@@ -0,0 +1,24 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+)
+
+// runStdoutStats prints one JSON stats line to stdout every
+// StdoutStatsIntervalSeconds for piping into jq or a log shipper. The
+// logger writes to stderr (or syslog) and each line goes out in a single
+// write, so the two streams never mix mid-line.
+func (app *App) runStdoutStats() {
+	ticker := time.NewTicker(seconds(app.currentConfig().StdoutStatsIntervalSeconds))
+	defer ticker.Stop()
+	encoder := json.NewEncoder(os.Stdout)
+	for range ticker.C {
+		if err := encoder.Encode(app.snapshot()); err != nil {
+			log.Printf("stdout stats: %v", err)
+			return
+		}
+	}
+}
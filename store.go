@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const checkpointFile = "current.json"
+
+var errSessionNotFound = errors.New("session not found")
+
+// SessionStore keeps finished sessions as one JSON file each in a
+// directory, named by session ID, plus a checkpoint of the session in
+// progress so a restart mid-ride can pick it back up.
+type SessionStore struct {
+	Dir   string
+	guard chan struct{}
+}
+
+type checkpoint struct {
+	Session Session
+	Pulses  uint64
+	SavedAt time.Time
+}
+
+func NewSessionStore(dir string) (*SessionStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &SessionStore{Dir: dir, guard: make(chan struct{}, 1)}, nil
+}
+
+func (s *SessionStore) lock()   { s.guard <- struct{}{} }
+func (s *SessionStore) unlock() { <-s.guard }
+
+func (s *SessionStore) recordPath(id int64) string {
+	return filepath.Join(s.Dir, fmt.Sprintf("%d.json", id))
+}
+
+// writeFile replaces path atomically so a power cut never leaves half a
+// file behind.
+func writeFile(path string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return writeBytes(path, data)
+}
+
+func writeBytes(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// finish stores a finished session and drops the checkpoint.
+func (s *SessionStore) finish(record SessionRecord) error {
+	s.lock()
+	defer s.unlock()
+	if record.pulseCount() > 0 {
+		if err := writeFile(s.recordPath(record.ID), record); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(filepath.Join(s.Dir, checkpointFile)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// saveCheckpoint writes the encoded checkpoint of session id, unless that
+// session has already been finished in the meantime.
+func (s *SessionStore) saveCheckpoint(id int64, data []byte) error {
+	s.lock()
+	defer s.unlock()
+	if _, err := os.Stat(s.recordPath(id)); err == nil {
+		return nil
+	}
+	return writeBytes(filepath.Join(s.Dir, checkpointFile), data)
+}
+
+func (s *SessionStore) loadCheckpoint() (checkpoint, bool, error) {
+	s.lock()
+	defer s.unlock()
+	data, err := os.ReadFile(filepath.Join(s.Dir, checkpointFile))
+	if os.IsNotExist(err) {
+		return checkpoint{}, false, nil
+	}
+	if err != nil {
+		return checkpoint{}, false, err
+	}
+	var cp checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return checkpoint{}, false, err
+	}
+	return cp, true, nil
+}
+
+// list returns the stored sessions, oldest first, without their tracks;
+// get has those.
+func (s *SessionStore) list() ([]SessionRecord, error) {
+	s.lock()
+	defer s.unlock()
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+	records := []SessionRecord{}
+	for _, entry := range entries {
+		id, ok := recordID(entry.Name())
+		if !ok {
+			continue
+		}
+		record, err := s.read(id)
+		if err != nil {
+			log.Printf("sessions: %s: %v", entry.Name(), err)
+			continue
+		}
+		record.Track = nil
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].ID < records[j].ID
+	})
+	return records, nil
+}
+
+// lastID returns the highest stored session ID, or 0 when there are none.
+func (s *SessionStore) lastID() (int64, error) {
+	s.lock()
+	defer s.unlock()
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return 0, err
+	}
+	var last int64
+	for _, entry := range entries {
+		if id, ok := recordID(entry.Name()); ok {
+			last = max(last, id)
+		}
+	}
+	return last, nil
+}
+
+func (s *SessionStore) get(id int64) (SessionRecord, error) {
+	s.lock()
+	defer s.unlock()
+	return s.read(id)
+}
+
+func (s *SessionStore) delete(id int64) error {
+	s.lock()
+	defer s.unlock()
+	err := os.Remove(s.recordPath(id))
+	if os.IsNotExist(err) {
+		return errSessionNotFound
+	}
+	return err
+}
+
+// read loads one record. Caller must hold the lock.
+func (s *SessionStore) read(id int64) (SessionRecord, error) {
+	data, err := os.ReadFile(s.recordPath(id))
+	if os.IsNotExist(err) {
+		return SessionRecord{}, errSessionNotFound
+	}
+	if err != nil {
+		return SessionRecord{}, err
+	}
+	var record SessionRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return SessionRecord{}, err
+	}
+	if record.ID == 0 {
+		// stored before sessions had IDs, when the file was named by start time
+		record.ID = id
+	}
+	return record, nil
+}
+
+func recordID(name string) (int64, bool) {
+	base, ok := strings.CutSuffix(name, ".json")
+	if !ok {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(base, 10, 64)
+	return id, err == nil
+}
+
+// attachStore starts keeping sessions in store. IDs carry on past the
+// ones already stored, so a clock set back after a reboot cannot reuse
+// one.
+func (app *App) attachStore(store *SessionStore) error {
+	last, err := store.lastID()
+	if err != nil {
+		return err
+	}
+	app.lock()
+	defer app.unlock()
+	app.store = store
+	if last > app.lastSessionID {
+		app.lastSessionID = last
+		if app.Session.ID <= last {
+			app.Session.ID = app.newSessionID(time.Now())
+		}
+	}
+	return nil
+}
+
+// runCheckpoints saves the session in progress every CheckpointSeconds.
+func (app *App) runCheckpoints() {
+	ticker := time.NewTicker(seconds(app.currentConfig().CheckpointSeconds))
+	defer ticker.Stop()
+	for range ticker.C {
+		app.checkpoint()
+	}
+}
+
+// checkpoint saves the session totals. Stats history and export samples
+// are not kept, so a resumed session starts those over.
+func (app *App) checkpoint() {
+	app.lock()
+	cp := checkpoint{Session: app.Session, Pulses: app.pulses.Load(), SavedAt: time.Now()}
+	// encode under the lock: the session's slices are still live
+	data, err := json.Marshal(cp)
+	app.unlock()
+	if err != nil {
+		log.Printf("sessions: checkpoint: %v", err)
+		return
+	}
+	if cp.Pulses == 0 {
+		return
+	}
+	if err := app.store.saveCheckpoint(cp.Session.ID, data); err != nil {
+		log.Printf("sessions: checkpoint: %v", err)
+	}
+}
+
+// resumeFromCheckpoint picks up a session interrupted by a restart. One
+// saved within ResumeWithinSeconds carries on; an older one is finished
+// instead, as the ride is clearly over, without counting toward today.
+func (app *App) resumeFromCheckpoint() {
+	cp, ok, err := app.store.loadCheckpoint()
+	if err != nil {
+		log.Printf("sessions: checkpoint: %v", err)
+		return
+	}
+	if !ok {
+		return
+	}
+	app.lock()
+	app.resumeSession(cp.Session, cp.Pulses)
+	id := app.Session.ID
+	app.unlock()
+
+	if time.Since(cp.SavedAt).Seconds() > app.currentConfig().ResumeWithinSeconds {
+		// it ended around the last checkpoint, before today's totals began
+		log.Printf("sessions: finishing session %d left over from %s", id, cp.SavedAt.Format(time.RFC3339))
+		app.finishSession(cp.SavedAt, false)
+		return
+	}
+	log.Printf("sessions: resumed session %d", id)
+}
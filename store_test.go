@@ -0,0 +1,119 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestResumeCheckpointAfterReboot resumes a checkpoint taken late in one
+// boot and carries on counting with a monotonic clock that restarted near
+// zero, as it does after a reboot.
+func TestResumeCheckpointAfterReboot(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.SessionsDir = t.TempDir()
+	cfg.CircumferenceInMetres = 1
+	cfg.SplitDistanceMetres = 5
+
+	store, err := NewSessionStore(cfg.SessionsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := NewApp(cfg)
+	before.store = store
+	// a slow ride: 2 s per revolution, an hour into the old boot
+	ts := time.Hour
+	for i := 0; i < 10; i++ {
+		ts += 2 * time.Second
+		before.countPulse(ts, debounceInterval)
+	}
+	before.checkpoint()
+
+	after := NewApp(cfg)
+	after.store = store
+	after.resumeFromCheckpoint()
+	// faster now: 1 s per revolution on a clock that starts again at 1 s
+	ts = time.Second
+	for i := 0; i < 10; i++ {
+		ts += time.Second
+		after.countPulse(ts, debounceInterval)
+	}
+
+	stats := after.snapshot()
+	if stats.TotalRevolutions != 20 {
+		t.Errorf("TotalRevolutions = %d, want 20", stats.TotalRevolutions)
+	}
+	// five revolutions at 1 s each
+	if stats.BestKilometreSeconds != 5 {
+		t.Errorf("BestKilometreSeconds = %v, want 5", stats.BestKilometreSeconds)
+	}
+	if stats.SpeedKilometresPerHour != 3.6 {
+		t.Errorf("SpeedKilometresPerHour = %v, want 3.6", stats.SpeedKilometresPerHour)
+	}
+	if a := stats.AccelerationMetresPerSecondSquared; a != 0 {
+		t.Errorf("AccelerationMetresPerSecondSquared = %v, want 0", a)
+	}
+}
+
+// TestSessionsInOneSecondKeepTheirRecords finishes two sessions well within
+// a second of each other and checks each keeps its own record, listed
+// without its track.
+func TestSessionsInOneSecondKeepTheirRecords(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.SessionsDir = t.TempDir()
+	store, err := NewSessionStore(cfg.SessionsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := NewApp(cfg)
+	if err := app.attachStore(store); err != nil {
+		t.Fatal(err)
+	}
+
+	ts := time.Duration(0)
+	for _, n := range []int{3, 5} {
+		for i := 0; i < n; i++ {
+			ts += time.Second
+			app.countPulse(ts, debounceInterval)
+		}
+		app.lock()
+		app.Session.Track.points = []TrackPoint{{}}
+		app.unlock()
+		app.reset()
+	}
+
+	records, err := store.list()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("listed %d sessions, want 2", len(records))
+	}
+	if records[0].ID == records[1].ID {
+		t.Fatalf("both sessions have ID %d", records[0].ID)
+	}
+	for i, want := range []uint64{3, 5} {
+		if got := records[i].TotalRevolutions; got != want {
+			t.Errorf("session %d: TotalRevolutions = %d, want %d", i, got, want)
+		}
+		if records[i].Track != nil {
+			t.Errorf("session %d: listed with its track", i)
+		}
+	}
+	record, err := store.get(records[1].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(record.Track) == 0 {
+		t.Error("get returned no track")
+	}
+
+	// a restart straight afterwards carries on past the stored IDs
+	next := NewApp(cfg)
+	if err := next.attachStore(store); err != nil {
+		t.Fatal(err)
+	}
+	if next.Session.ID <= records[1].ID {
+		t.Errorf("new session ID %d not after stored %d", next.Session.ID, records[1].ID)
+	}
+}
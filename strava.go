@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	stravaTokenURL  = "https://www.strava.com/oauth/token"
+	stravaUploadURL = "https://www.strava.com/api/v3/uploads"
+)
+
+// Strava uploads finished rides as FIT files using an OAuth refresh token,
+// refreshing the short-lived access token as it expires.
+type Strava struct {
+	ClientID     string
+	ClientSecret string
+	RefreshToken string
+
+	client      *http.Client
+	accessToken string
+	expiry      time.Time
+	guard       chan struct{}
+}
+
+func NewStrava(clientID, clientSecret, refreshToken string) *Strava {
+	return &Strava{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RefreshToken: refreshToken,
+		client:       &http.Client{Timeout: 30 * time.Second},
+		guard:        make(chan struct{}, 1),
+	}
+}
+
+// token returns a valid access token, refreshing it when close to expiry.
+// Strava may rotate the refresh token, so the new one is kept.
+func (s *Strava) token() (string, error) {
+	s.guard <- struct{}{}
+	defer func() { <-s.guard }()
+
+	if s.accessToken != "" && time.Now().Before(s.expiry.Add(-time.Minute)) {
+		return s.accessToken, nil
+	}
+	res, err := s.client.PostForm(stravaTokenURL, url.Values{
+		"client_id":     {s.ClientID},
+		"client_secret": {s.ClientSecret},
+		"refresh_token": {s.RefreshToken},
+		"grant_type":    {"refresh_token"},
+	})
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token refresh: %s", res.Status)
+	}
+	var body struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		ExpiresAt    int64  `json:"expires_at"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("token refresh: %w", err)
+	}
+	s.accessToken = body.AccessToken
+	s.expiry = time.Unix(body.ExpiresAt, 0)
+	if body.RefreshToken != "" {
+		s.RefreshToken = body.RefreshToken
+	}
+	return s.accessToken, nil
+}
+
+// upload sends the ride as a FIT file and returns Strava's upload ID.
+// Strava processes uploads asynchronously; the activity appears shortly
+// after.
+func (s *Strava) upload(record SessionRecord) (int64, error) {
+	token, err := s.token()
+	if err != nil {
+		return 0, err
+	}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	_ = form.WriteField("data_type", "fit")
+	_ = form.WriteField("name", "vital ride")
+	_ = form.WriteField("trainer", "1")
+	_ = form.WriteField("external_id", fmt.Sprintf("vital-%d", record.ID))
+	if len(record.Tags) > 0 {
+		_ = form.WriteField("description", strings.Join(record.Tags, ", "))
+	}
+	file, err := form.CreateFormFile("file", fmt.Sprintf("vital-%d.fit", record.ID))
+	if err != nil {
+		return 0, err
+	}
+	if _, err := file.Write(recordExport(record).fit()); err != nil {
+		return 0, err
+	}
+	if err := form.Close(); err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, stravaUploadURL, &body)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	res, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return 0, fmt.Errorf("upload: %s %s", res.Status, strings.TrimSpace(string(detail)))
+	}
+	var created struct {
+		ID int64 `json:"id"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&created); err != nil {
+		return 0, fmt.Errorf("upload: %w", err)
+	}
+	return created.ID, nil
+}
+
+func (s *Strava) uploadAndLog(record SessionRecord) {
+	id, err := s.upload(record)
+	if err != nil {
+		log.Printf("strava: %v", err)
+		return
+	}
+	log.Printf("strava: created upload %d", id)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// broadcaster wakes every stats stream when a pulse arrives. Wakeups are
+// level-triggered and coalesce, so a slow client never holds up onEdge.
+type broadcaster struct {
+	guard chan struct{}
+	subs  map[chan struct{}]struct{}
+	// closed on shutdown so open streams end and the server can drain
+	done chan struct{}
+}
+
+func newBroadcaster() *broadcaster {
+	return &broadcaster{
+		guard: make(chan struct{}, 1),
+		subs:  map[chan struct{}]struct{}{},
+		done:  make(chan struct{}),
+	}
+}
+
+func (b *broadcaster) stop() { close(b.done) }
+
+func (b *broadcaster) subscribe() chan struct{} {
+	ch := make(chan struct{}, 1)
+	b.guard <- struct{}{}
+	b.subs[ch] = struct{}{}
+	<-b.guard
+	return ch
+}
+
+func (b *broadcaster) unsubscribe(ch chan struct{}) {
+	b.guard <- struct{}{}
+	delete(b.subs, ch)
+	<-b.guard
+}
+
+func (b *broadcaster) notify() {
+	b.guard <- struct{}{}
+	for ch := range b.subs {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+	<-b.guard
+}
+
+// streamStats serves Server-Sent Events with a stats snapshot every
+// StreamIntervalSeconds and straight after each pulse. Snapshots are
+// read-only and accrual runs on the snapshot ticker, so however many
+// clients watch, the numbers come out the same.
+func (app *App) streamStats(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		pulses := app.streams.subscribe()
+		defer app.streams.unsubscribe(pulses)
+
+		ticker := time.NewTicker(seconds(app.currentConfig().StreamIntervalSeconds))
+		defer ticker.Stop()
+		for {
+			data, err := json.Marshal(app.snapshot())
+			if err != nil {
+				return
+			}
+			if _, err := w.WriteString("data: "); err != nil {
+				return
+			}
+			_, _ = w.Write(data)
+			_, _ = w.WriteString("\n\n")
+			// a failed flush means the client went away
+			if err := w.Flush(); err != nil {
+				return
+			}
+			select {
+			case <-ticker.C:
+			case <-pulses:
+			case <-app.streams.done:
+				return
+			}
+		}
+	})
+	return nil
+}
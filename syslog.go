@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"log/syslog"
+	"net/url"
+)
+
+// useSyslog routes the standard logger to syslog. address is "local" for
+// the local daemon or "udp://host:port" / "tcp://host:port" for a remote
+// collector.
+func useSyslog(address string) error {
+	network, raddr := "", ""
+	if address != "local" {
+		u, err := url.Parse(address)
+		if err != nil || (u.Scheme != "udp" && u.Scheme != "tcp") || u.Host == "" {
+			return fmt.Errorf("SyslogAddress %q must be \"local\" or udp://host:port / tcp://host:port", address)
+		}
+		network, raddr = u.Scheme, u.Host
+	}
+	writer, err := syslog.Dial(network, raddr, syslog.LOG_INFO|syslog.LOG_DAEMON, "vital")
+	if err != nil {
+		return err
+	}
+	log.SetOutput(writer)
+	// syslog stamps each message itself
+	log.SetFlags(0)
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"time"
+)
+
+// serveStatsTCP pushes one JSON stats line per second to every client
+// connected to the listener, for displays that only speak plain TCP.
+func (app *App) serveStatsTCP(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			log.Printf("stats tcp: %v", err)
+			continue
+		}
+		go app.streamStatsTCP(conn)
+	}
+}
+
+func (app *App) streamStatsTCP(conn net.Conn) {
+	defer conn.Close()
+
+	// clients never send anything; a read returning means they hung up
+	closed := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(io.Discard, conn)
+		close(closed)
+	}()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	encoder := json.NewEncoder(conn)
+	for {
+		_ = conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		if err := encoder.Encode(app.snapshot()); err != nil {
+			return
+		}
+		select {
+		case <-closed:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type TrackPoint struct {
+	Time           time.Time `json:"time"`
+	DistanceMetres float64   `json:"distanceMetres"`
+	SpeedKmh       float64   `json:"speedKmh"`
+	CadenceRpm     float64   `json:"cadenceRpm,omitempty"`
+	Watts          float64   `json:"watts,omitempty"`
+}
+
+// Track holds time-series samples oldest first, for export.
+type Track struct {
+	points []TrackPoint
+}
+
+func (t *Track) add(point TrackPoint) { t.points = append(t.points, point) }
+
+func (t *Track) len() int { return len(t.points) }
+
+func (t *Track) last() (TrackPoint, bool) {
+	if len(t.points) == 0 {
+		return TrackPoint{}, false
+	}
+	return t.points[len(t.points)-1], true
+}
+
+func (t *Track) oldest() (time.Time, bool) {
+	if len(t.points) == 0 {
+		return time.Time{}, false
+	}
+	return t.points[0].Time, true
+}
+
+func (t *Track) dropOldest() {
+	if len(t.points) > 0 {
+		t.points = t.points[1:]
+	}
+}
+
+// recordTrackPoint samples distance, speed, cadence and power every
+// TrackpointIntervalSeconds once the session has pulses. Caller must hold
+// the lock.
+func (app *App) recordTrackPoint(now time.Time, stats Stats) {
+	interval := app.Config.TrackpointIntervalSeconds
+	if interval <= 0 || stats.DistanceKilometres == 0 {
+		return
+	}
+	if last, ok := app.Session.Track.last(); ok && now.Sub(last.Time).Seconds() < interval {
+		return
+	}
+	app.Session.Track.add(TrackPoint{
+		Time:           now,
+		DistanceMetres: stats.DistanceKilometres * 1000.0,
+		SpeedKmh:       stats.SpeedKilometresPerHour,
+		CadenceRpm:     stats.CadenceRpm,
+		Watts:          stats.EstimatedWatts,
+	})
+	app.enforceSampleBudget()
+}
+
+// rideExport is what the TCX and FIT writers need, from either the live
+// session or a stored one.
+type rideExport struct {
+	Start          time.Time
+	End            time.Time
+	MovingSeconds  float64
+	DistanceMetres float64
+	KiloCalories   float64
+	Tags           []string
+	Points         []TrackPoint
+}
+
+// currentExport copies out the live session as of the last tick.
+func (app *App) currentExport() rideExport {
+	app.lock()
+	defer app.unlock()
+	return rideExport{
+		Start:          time.Unix(app.Session.StartTimeEpochSeconds, 0),
+		End:            time.Now(),
+		MovingSeconds:  app.Session.MovingSeconds,
+		DistanceMetres: app.distanceMetres(app.pulses.Load()),
+		KiloCalories:   app.Session.KiloCalories,
+		Tags:           slices.Clone(app.Session.Tags),
+		Points:         slices.Clone(app.Session.Track.points),
+	}
+}
+
+func recordExport(record SessionRecord) rideExport {
+	return rideExport{
+		Start:          time.Unix(record.StartTimeEpochSeconds, 0),
+		End:            time.Unix(record.EndTimeEpochSeconds, 0),
+		MovingSeconds:  record.MovingMinutes * 60.0,
+		DistanceMetres: record.DistanceKilometres * 1000.0,
+		KiloCalories:   record.KiloCalories,
+		Tags:           record.Tags,
+		Points:         record.Track,
+	}
+}
+
+type tcxDatabase struct {
+	XMLName    xml.Name      `xml:"TrainingCenterDatabase"`
+	Xmlns      string        `xml:"xmlns,attr"`
+	Activities []tcxActivity `xml:"Activities>Activity"`
+}
+
+type tcxActivity struct {
+	Sport string `xml:"Sport,attr"`
+	Id    string `xml:"Id"`
+	Lap   tcxLap `xml:"Lap"`
+	Notes string `xml:"Notes,omitempty"`
+}
+
+type tcxLap struct {
+	StartTime        string    `xml:"StartTime,attr"`
+	TotalTimeSeconds float64   `xml:"TotalTimeSeconds"`
+	DistanceMeters   float64   `xml:"DistanceMeters"`
+	Calories         int       `xml:"Calories"`
+	Intensity        string    `xml:"Intensity"`
+	TriggerMethod    string    `xml:"TriggerMethod"`
+	Track            *tcxTrack `xml:"Track,omitempty"`
+}
+
+type tcxTrack struct {
+	Trackpoints []tcxTrackpoint `xml:"Trackpoint"`
+}
+
+type tcxTrackpoint struct {
+	Time           string  `xml:"Time"`
+	DistanceMeters float64 `xml:"DistanceMeters"`
+	Cadence        int     `xml:"Cadence,omitempty"`
+}
+
+// tcx renders the ride as a single-lap TCX activity. A ride without
+// samples gets a lap with no track, which is still valid.
+func (e rideExport) tcx() ([]byte, error) {
+	start := e.Start.UTC().Format(time.RFC3339)
+	lap := tcxLap{
+		StartTime:        start,
+		TotalTimeSeconds: round(e.MovingSeconds, 1),
+		DistanceMeters:   round(e.DistanceMetres, 1),
+		Calories:         int(math.Round(e.KiloCalories)),
+		Intensity:        "Active",
+		TriggerMethod:    "Manual",
+	}
+	if len(e.Points) > 0 {
+		lap.Track = &tcxTrack{}
+		for _, p := range e.Points {
+			lap.Track.Trackpoints = append(lap.Track.Trackpoints, tcxTrackpoint{
+				Time:           p.Time.UTC().Format(time.RFC3339),
+				DistanceMeters: round(p.DistanceMetres, 1),
+				// TCX caps cadence at 254
+				Cadence: int(math.Min(254, math.Round(p.CadenceRpm))),
+			})
+		}
+	}
+
+	doc := tcxDatabase{
+		Xmlns:      "http://www.garmin.com/xmlschemas/TrainingCenterDatabase/v2",
+		Activities: []tcxActivity{{Sport: "Biking", Id: start, Lap: lap, Notes: strings.Join(e.Tags, ", ")}},
+	}
+	body, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
+
+// sendExport answers with the ride as a TCX or FIT attachment.
+func sendExport(c *fiber.Ctx, export rideExport, format string) error {
+	name := fmt.Sprintf("vital-%d.%s", export.Start.Unix(), format)
+	switch format {
+	case "tcx":
+		body, err := export.tcx()
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(ApiResponse{Data: fiber.Map{}, Message: err.Error()})
+		}
+		c.Attachment(name)
+		c.Set(fiber.HeaderContentType, "application/vnd.garmin.tcx+xml")
+		return c.Send(body)
+	case "fit":
+		c.Attachment(name)
+		c.Set(fiber.HeaderContentType, "application/vnd.ant.fit")
+		return c.Send(export.fit())
+	}
+	return c.Status(fiber.StatusBadRequest).JSON(ApiResponse{Data: fiber.Map{}, Message: "format must be tcx or fit"})
+}
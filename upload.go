@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Uploader POSTs finished sessions to a remote endpoint. Sessions wait in a
+// spool until the endpoint accepts them, so rides recorded while the server
+// is unreachable are delivered once it comes back. With a SpoolDir each
+// spooled session is also written to disk and survives restarts.
+type Uploader struct {
+	Endpoint   string
+	RetryEvery time.Duration
+	SpoolDir   string
+	// MaxSpool bounds the spool; the oldest session is dropped when full.
+	MaxSpool int
+
+	client *http.Client
+	spool  []spooled
+	seq    uint64
+	guard  chan struct{}
+	wake   chan struct{}
+}
+
+type spooled struct {
+	Record SessionRecord
+	// file backing the entry in SpoolDir, empty when not persisted
+	Path string
+	seq  uint64
+}
+
+func NewUploader(endpoint string, retryEvery time.Duration, spoolDir string, maxSpool int) *Uploader {
+	return &Uploader{
+		Endpoint:   endpoint,
+		RetryEvery: retryEvery,
+		SpoolDir:   spoolDir,
+		MaxSpool:   maxSpool,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		guard:      make(chan struct{}, 1),
+		wake:       make(chan struct{}, 1),
+	}
+}
+
+// loadSpool picks up sessions left in SpoolDir by a previous run, oldest
+// first.
+func (u *Uploader) loadSpool() error {
+	if u.SpoolDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(u.SpoolDir, 0o755); err != nil {
+		return err
+	}
+	paths, err := filepath.Glob(filepath.Join(u.SpoolDir, "*.json"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(paths)
+
+	u.lock()
+	defer u.unlock()
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var record SessionRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			log.Printf("upload: skipping unreadable spool file %s: %v", path, err)
+			continue
+		}
+		if record.ID == 0 {
+			// spooled before sessions had IDs
+			record.ID = record.StartTimeEpochSeconds
+		}
+		u.push(spooled{Record: record, Path: path})
+	}
+	if len(u.spool) > 0 {
+		log.Printf("upload: %d spooled session(s) waiting from a previous run", len(u.spool))
+	}
+	return nil
+}
+
+// persist writes the record to SpoolDir and returns the file name, or ""
+// when there is no spool dir or the write fails.
+func (u *Uploader) persist(record SessionRecord) string {
+	if u.SpoolDir == "" {
+		return ""
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		log.Printf("upload: spool: %v", err)
+		return ""
+	}
+	// zero-padded so lexical order is delivery order
+	path := filepath.Join(u.SpoolDir, fmt.Sprintf("%020d-%020d.json", record.ID, record.EndTimeEpochSeconds))
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		log.Printf("upload: spool: %v", err)
+		return ""
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		log.Printf("upload: spool: %v", err)
+		_ = os.Remove(tmp)
+		return ""
+	}
+	return path
+}
+
+func (u *Uploader) forget(entry spooled) {
+	if entry.Path == "" {
+		return
+	}
+	if err := os.Remove(entry.Path); err != nil && !os.IsNotExist(err) {
+		log.Printf("upload: spool: %v", err)
+	}
+}
+
+// push appends to the spool, dropping the oldest entries over MaxSpool.
+// Caller must hold the lock.
+func (u *Uploader) push(entry spooled) {
+	u.seq++
+	entry.seq = u.seq
+	u.spool = append(u.spool, entry)
+	for u.MaxSpool > 0 && len(u.spool) > u.MaxSpool {
+		dropped := u.spool[0]
+		u.spool = u.spool[1:]
+		u.forget(dropped)
+		log.Printf("upload: spool full, dropped session %d", dropped.Record.ID)
+	}
+}
+
+func (u *Uploader) lock()   { u.guard <- struct{}{} }
+func (u *Uploader) unlock() { <-u.guard }
+
+func (u *Uploader) enqueue(record SessionRecord) {
+	entry := spooled{Record: record, Path: u.persist(record)}
+	u.lock()
+	u.push(entry)
+	u.unlock()
+
+	select {
+	case u.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run flushes the spool whenever a session is enqueued and retries on a
+// fixed interval while anything is left in it.
+func (u *Uploader) run() {
+	ticker := time.NewTicker(u.RetryEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-u.wake:
+		case <-ticker.C:
+		}
+		u.flush()
+	}
+}
+
+// flush sends spooled sessions oldest first, stopping at the first failure
+// so ordering is preserved for the next attempt.
+func (u *Uploader) flush() {
+	for {
+		u.lock()
+		if len(u.spool) == 0 {
+			u.unlock()
+			return
+		}
+		entry := u.spool[0]
+		u.unlock()
+
+		if err := u.post(entry.Record); err != nil {
+			log.Printf("upload: %v (will retry in %s)", err, u.RetryEvery)
+			return
+		}
+
+		u.lock()
+		// the entry may have been dropped for space while posting
+		if len(u.spool) > 0 && u.spool[0].seq == entry.seq {
+			u.spool = u.spool[1:]
+		}
+		u.unlock()
+		u.forget(entry)
+		log.Printf("upload: session %d delivered", entry.Record.ID)
+	}
+}
+
+func (u *Uploader) post(record SessionRecord) error {
+	body, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	res, err := u.client.Post(u.Endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("endpoint returned %s", res.Status)
+	}
+	return nil
+}